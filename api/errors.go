@@ -4,16 +4,29 @@ import (
 	"check42/api/router"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 )
 
 // helper functions for returning errors from ProcessFuncs and for returning early from HandlerFunc
 
-func fail(w http.ResponseWriter, status int, msg string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	io.WriteString(w, `{"error":"`+msg+`"}`)
+func fail(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	router.WriteError(w, r, status, errors.New(msg))
+}
+
+// Decode the JSON body into v for handlers outside the router's binding.
+// Bodies over router.MaxBodyBytes are answered with 413, unreadable ones
+// with 400 and msg. Reports whether the handler may go on.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any, msg string) bool {
+	status := router.DecodeBody(r, v)
+	switch {
+	case status.Code == http.StatusRequestEntityTooLarge:
+		router.WriteError(w, r, status.Code, status.Err)
+		return false
+	case status.Err != nil:
+		fail(w, r, http.StatusBadRequest, msg)
+		return false
+	}
+	return true
 }
 
 var statusOK = router.HttpStatus{
@@ -31,9 +44,17 @@ var internalError = router.HttpStatus{
 	Err:  errors.New("internal error"),
 }
 
+// The cause is logged by the router but not exposed to the client.
 func internalErrorCause(cause error) router.HttpStatus {
-	fmt.Println("Internal error:", cause)
-	return internalError
+	return router.HttpStatus{
+		Code: http.StatusInternalServerError,
+		Err:  cause,
+	}
+}
+
+var preconditionFailed = router.HttpStatus{
+	Code: http.StatusPreconditionFailed,
+	Err:  errors.New("resource has been modified"),
 }
 
 func badRequestCause(cause error) router.HttpStatus {
@@ -49,3 +70,10 @@ func notFound(id int64) router.HttpStatus {
 		Err:  fmt.Errorf("no item %d found", id),
 	}
 }
+
+func conflict(id int64) router.HttpStatus {
+	return router.HttpStatus{
+		Code: http.StatusConflict,
+		Err:  fmt.Errorf("item %d has been modified concurrently, reload and retry", id),
+	}
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"check42/api/router"
+	"check42/api/totp"
+	"check42/model"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const recoveryCodeCount = 10
+
+// Generate a new TOTP secret for the user. The secret is only required
+// on login once it has been confirmed with a valid code.
+//
+// POST /api/2fa
+func (s server) handleEnroll2FA(r *http.Request) (model.TOTPEnrollment, router.HttpStatus) {
+	user, status := s.currentUser(r)
+	if status.Err != nil {
+		return model.TOTPEnrollment{}, status
+	}
+	if user.TOTPEnabled {
+		return model.TOTPEnrollment{}, badRequestCause(errors.New("2fa is already enabled"))
+	}
+
+	secret := totp.GenerateSecret()
+	if err := s.users.SetTOTPSecret(r.Context(), user.ID, secret); err != nil {
+		return model.TOTPEnrollment{}, internalErrorCause(err)
+	}
+	return model.TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.ProvisioningURI("check42", user.Name, secret),
+	}, statusCreated
+}
+
+// Enable 2FA after checking a code generated from the new secret.
+// Returns the recovery codes which are not shown again.
+//
+// POST /api/2fa/confirm?code={code}
+func (s server) handleConfirm2FA(r *http.Request) ([]string, router.HttpStatus) {
+	user, status := s.currentUser(r)
+	if status.Err != nil {
+		return nil, status
+	}
+	if user.TOTPEnabled {
+		return nil, badRequestCause(errors.New("2fa is already enabled"))
+	}
+	if user.TOTPSecret == "" {
+		return nil, badRequestCause(errors.New("2fa enrollment has not been started"))
+	}
+	step, ok := totp.Validate(user.TOTPSecret, r.URL.Query().Get("code"), time.Now())
+	if !ok {
+		return nil, badRequestCause(errors.New("incorrect 'code'"))
+	}
+	// the code must not be replayed to log in right afterwards
+	fresh, err := s.users.UseTOTPStep(r.Context(), user.ID, step)
+	if err != nil {
+		return nil, internalErrorCause(err)
+	}
+	if !fresh {
+		return nil, badRequestCause(errors.New("'code' has already been used"))
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i] = newRecoveryCode()
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	if err := s.users.EnableTOTP(r.Context(), user.ID, hashes); err != nil {
+		return nil, internalErrorCause(err)
+	}
+	return codes, statusOK
+}
+
+// Disable 2FA. Requires a current code or one of the recovery codes.
+//
+// DELETE /api/2fa?code={code}
+func (s server) handleDisable2FA(r *http.Request) router.HttpStatus {
+	user, status := s.currentUser(r)
+	if status.Err != nil {
+		return status
+	}
+	if !user.TOTPEnabled {
+		return badRequestCause(errors.New("2fa is not enabled"))
+	}
+	ok, err := s.verifySecondFactor(r.Context(), user, r.URL.Query().Get("code"))
+	if err != nil {
+		return internalErrorCause(err)
+	}
+	if !ok {
+		return badRequestCause(errors.New("incorrect 'code'"))
+	}
+	if err := s.users.DisableTOTP(r.Context(), user.ID); err != nil {
+		return internalErrorCause(err)
+	}
+	return statusOK
+}
+
+// Look up the full user record for the claims of the request.
+func (s server) currentUser(r *http.Request) (model.User, router.HttpStatus) {
+	claims, ok := router.GetClaims(r)
+	if !ok {
+		return model.User{}, internalError
+	}
+	user, err := s.users.GetUserByID(r.Context(), int(claims.ID))
+	if err != nil {
+		return model.User{}, internalErrorCause(err)
+	}
+	return user, statusOK
+}
+
+// Accept either a valid TOTP code that has not been used before or an unused
+// recovery code, which is consumed.
+func (s server) verifySecondFactor(ctx context.Context, user model.User, code string) (bool, error) {
+	if code == "" {
+		return false, nil
+	}
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+		return s.users.UseTOTPStep(ctx, user.ID, step)
+	}
+	return s.users.UseRecoveryCode(ctx, user.ID, hashRecoveryCode(code))
+}
+
+func newRecoveryCode() string {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+	return code[:5] + "-" + code[5:10] + "-" + code[10:15]
+}
+
+// Recovery codes are random enough to be stored as plain SHA-256 hashes.
+// Dashes and case are ignored so codes can be typed in however they are read.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
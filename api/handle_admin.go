@@ -0,0 +1,98 @@
+package api
+
+import (
+	"check42/api/router"
+	"check42/model"
+	"check42/store/stores"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// GET /admin/users
+func (s server) handleGetUsers(r *http.Request) ([]model.Profile, router.HttpStatus) {
+	users, err := s.users.GetAllUsers(r.Context())
+	if err != nil {
+		return nil, internalErrorCause(err)
+	}
+	profiles := make([]model.Profile, len(users))
+	for i, u := range users {
+		profiles[i] = u.Profile()
+	}
+	return profiles, statusOK
+}
+
+// POST /admin/users/{id}/disable
+func (s server) handleDisableUser(r *http.Request) router.HttpStatus {
+	return s.setDisabled(r, true)
+}
+
+// POST /admin/users/{id}/enable
+func (s server) handleEnableUser(r *http.Request) router.HttpStatus {
+	return s.setDisabled(r, false)
+}
+
+func (s server) setDisabled(r *http.Request, disabled bool) router.HttpStatus {
+	claims, ok := router.GetClaims(r)
+	if !ok {
+		return internalError
+	}
+
+	pathValue := r.PathValue("id")
+	userID, err := strconv.ParseInt(pathValue, 10, 64)
+	if err != nil {
+		return badRequestCause(errors.New("incorrect 'id'"))
+	}
+	if userID == claims.ID {
+		return badRequestCause(errors.New("cannot change your own account"))
+	}
+
+	err = s.users.SetDisabled(r.Context(), userID, disabled)
+	if err == stores.ErrNotFound {
+		return notFound(userID)
+	}
+	if err != nil {
+		return internalErrorCause(err)
+	}
+	return statusOK
+}
+
+// Remove the user's password, end their sessions and send them a reset mail.
+// Until the reset is done they can't log in with a password.
+//
+// POST /admin/users/{id}/reset-password
+func (s server) handleForcePasswordReset(r *http.Request) router.HttpStatus {
+	pathValue := r.PathValue("id")
+	userID, err := strconv.ParseInt(pathValue, 10, 64)
+	if err != nil {
+		return badRequestCause(errors.New("incorrect 'id'"))
+	}
+
+	user, err := s.users.GetUserByID(r.Context(), int(userID))
+	if err == stores.ErrNotFound {
+		return notFound(userID)
+	}
+	if err != nil {
+		return internalErrorCause(err)
+	}
+	if err := s.users.ClearPassword(r.Context(), user.ID); err != nil {
+		return internalErrorCause(err)
+	}
+	if err := s.sendPasswordReset(r.Context(), user); err != nil {
+		router.Logger(r).Error("Sending password reset mail failed", "err", err)
+	}
+	return statusOK
+}
+
+// GET /admin/stats
+func (s server) handleGetStats(r *http.Request) (model.SystemStats, router.HttpStatus) {
+	users, err := s.users.GetUserStats(r.Context())
+	if err != nil {
+		return model.SystemStats{}, internalErrorCause(err)
+	}
+	todos, err := s.todos.GetTodoStats(r.Context())
+	if err != nil {
+		return model.SystemStats{}, internalErrorCause(err)
+	}
+	return model.SystemStats{UserStats: users, TodoStats: todos}, statusOK
+}
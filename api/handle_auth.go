@@ -4,36 +4,47 @@ import (
 	"check42/api/router"
 	"check42/model"
 	"check42/store/stores"
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
 	"net/http"
-	"os"
 	"time"
 
 	jwt "github.com/golang-jwt/jwt/v4"
 )
 
-// Create a new user from the provided JSON body and save it
+// Create a new user from the provided JSON body and save it.
+// A verification link is sent to the email, the user can only log in
+// after opening it.
 //
 // POST /auth/signin
 func (s server) handleSignin(w http.ResponseWriter, r *http.Request) {
 	var u model.CreateUser
-	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
-		fail(w, http.StatusBadRequest, "could not read user")
+	if !decodeBody(w, r, &u, "could not read user") {
 		return
 	}
 	if err := u.Validate(); err.Err() {
-		fail(w, http.StatusBadRequest, err.Error())
+		router.WriteError(w, r, http.StatusBadRequest, err)
 		return
 	}
-	if err := s.users.CreateUser(u); err != nil {
+	if err := s.users.CreateUser(r.Context(), u); err != nil {
 		switch err {
 		case stores.ErrUsernameTaken, stores.ErrEmailTaken:
-			fail(w, http.StatusBadRequest, err.Error())
+			fail(w, r, http.StatusBadRequest, err.Error())
 		default:
-			fail(w, http.StatusInternalServerError, err.Error())
+			fail(w, r, http.StatusInternalServerError, "internal error")
 		}
 		return
 	}
+	user, err := s.users.GetUserByName(r.Context(), u.Name)
+	if err != nil {
+		fail(w, r, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if err := s.sendVerification(r.Context(), user); err != nil {
+		router.Logger(r).Error("Sending verification mail failed", "err", err)
+	}
 	w.WriteHeader(201)
 }
 
@@ -41,51 +52,192 @@ func (s server) handleSignin(w http.ResponseWriter, r *http.Request) {
 // The endpoint is protected through the BasicAuth middleware which
 // also provides the claims used to construct the JWT.
 //
+// Users with 2FA enabled instead receive a short lived cookie and a 202
+// response. The login is then completed through POST /auth/2fa.
+//
 // POST /auth/login
 func (s server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	claims, ok := router.GetClaims(r)
 	if !ok {
-		fail(w, http.StatusInternalServerError, "internal error")
+		fail(w, r, http.StatusInternalServerError, "internal error")
+		return
+	}
+	user, err := s.users.GetUserByID(r.Context(), int(claims.ID))
+	if err != nil {
+		fail(w, r, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if user.TOTPEnabled {
+		if err := s.startPendingLogin(w, r, user.ID); err != nil {
+			fail(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, `{"2fa_required":true}`)
+		return
+	}
+	if err := s.startSession(w, r, claims); err != nil {
+		fail(w, r, http.StatusInternalServerError, "internal error")
+		return
+	}
+}
+
+// Complete a login started at POST /auth/login or an OIDC callback with a
+// TOTP or recovery code.
+//
+// POST /auth/2fa?code={code}
+func (s server) handleLogin2FA(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(pendingLoginCookie)
+	if err != nil {
+		fail(w, r, http.StatusUnauthorized, "no pending login")
+		return
+	}
+	userID, loginID, err := parsePendingLogin(c.Value, s.auth.jwtSecret)
+	if err != nil {
+		fail(w, r, http.StatusUnauthorized, "no pending login")
+		return
+	}
+	key := "2fa:" + loginID
+	if s.pendingLogins.Locked(key) > 0 {
+		http.SetCookie(w, s.expiredCookie(r, pendingLoginCookie, "/auth/"))
+		fail(w, r, http.StatusUnauthorized, "too many incorrect codes, log in again")
+		return
+	}
+	user, err := s.users.GetUserByID(r.Context(), int(userID))
+	if err != nil || user.Disabled {
+		fail(w, r, http.StatusUnauthorized, "no pending login")
+		return
+	}
+	ok, err := s.verifySecondFactor(r.Context(), user, r.URL.Query().Get("code"))
+	if err != nil {
+		fail(w, r, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if !ok {
+		if failures, _ := s.pendingLogins.Failed(key); failures >= maxSecondFactorFailures {
+			http.SetCookie(w, s.expiredCookie(r, pendingLoginCookie, "/auth/"))
+			fail(w, r, http.StatusUnauthorized, "too many incorrect codes, log in again")
+			return
+		}
+		fail(w, r, http.StatusUnauthorized, "incorrect code")
+		return
+	}
+
+	s.pendingLogins.Succeeded(key)
+	http.SetCookie(w, s.expiredCookie(r, pendingLoginCookie, "/auth/"))
+	if err := s.startSession(w, r, user.Claims()); err != nil {
+		fail(w, r, http.StatusInternalServerError, "internal error")
 		return
 	}
+}
+
+// Sign a JWT for the given claims and set it as the session cookie.
+func (s server) startSession(w http.ResponseWriter, r *http.Request, claims *router.Claims) error {
 	week := time.Duration(7 * 24 * time.Hour)
 	jwtClaims := jwt.MapClaims{
-		"sub": claims.Name,
-		"id":  claims.ID,
-		"exp": jwt.NumericDate{Time: time.Now().Add(week)},
+		"sub":  claims.Name,
+		"id":   claims.ID,
+		"role": claims.Role,
+		"ses":  claims.Session,
+		"exp":  jwt.NumericDate{Time: time.Now().Add(week)},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwtClaims)
 
-	secret, found := os.LookupEnv("JWT_SECRET")
-	if !found {
-		fail(w, http.StatusInternalServerError, "internal error")
-		return
+	signed, err := token.SignedString(s.auth.jwtSecret)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, s.newCookie(r, "jwt", signed, "/", time.Now().Add(week)))
+	return nil
+}
+
+const (
+	pendingLoginCookie      = "jwt_2fa"
+	pendingLoginTTL         = 5 * time.Minute
+	maxSecondFactorFailures = 5
+)
+
+// Failures per pending login. The lock after the last allowed failure outlasts
+// the pending token, which makes it void.
+var pendingLoginPolicy = router.ThrottlePolicy{
+	FreeAttempts: maxSecondFactorFailures - 1,
+	BaseDelay:    pendingLoginTTL,
+	MaxDelay:     pendingLoginTTL,
+	Window:       pendingLoginTTL,
+}
+
+// Remember a user that passed the password check but still has to provide
+// their second factor. The token deliberately lacks the 'id' claim so it is
+// never accepted as a session. Its 'jti' identifies the pending login to
+// count the failed codes.
+func (s server) startPendingLogin(w http.ResponseWriter, r *http.Request, userID int64) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
 	}
-	signed, err := token.SignedString([]byte(secret))
+	expires := time.Now().Add(pendingLoginTTL)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"2fa": userID,
+		"jti": hex.EncodeToString(b),
+		"exp": jwt.NumericDate{Time: expires},
+	})
+	signed, err := token.SignedString(s.auth.jwtSecret)
 	if err != nil {
-		fail(w, http.StatusInternalServerError, "internal error")
-		return
+		return err
 	}
+	http.SetCookie(w, s.newCookie(r, pendingLoginCookie, signed, "/auth/", expires))
+	return nil
+}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "jwt",
-		Value:    signed,
-		Expires:  time.Now().Add(week),
-		HttpOnly: true,
-		Path:     "/",
+func parsePendingLogin(payload string, secret []byte) (int64, string, error) {
+	raw := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(payload, raw, func(t *jwt.Token) (any, error) {
+		if alg := t.Method.Alg(); alg != jwt.SigningMethodHS256.Alg() {
+			return nil, errors.New("incorrect signing method: " + alg)
+		}
+		return secret, nil
 	})
+	if err != nil {
+		return 0, "", err
+	}
+	id, ok := raw["2fa"].(float64)
+	if !ok {
+		return 0, "", errors.New("invalid field '2fa'")
+	}
+	jti, ok := raw["jti"].(string)
+	if !ok || jti == "" {
+		return 0, "", errors.New("invalid field 'jti'")
+	}
+	return int64(id), jti, nil
 }
 
 // Returns an expired JWT irregardless of the user's login status, effectively logging them out.
 //
 // POST /auth/logout
-func (s server) handleLogout(w http.ResponseWriter, _ *http.Request) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     "jwt",
-		Value:    "",
-		Expires:  time.Now().Add(time.Duration(-1 * time.Hour)), // already expired
+func (s server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, s.expiredCookie(r, "jwt", "/"))
+}
+
+// Cookies are HttpOnly and not sent along with cross-site subrequests.
+// They are Secure if the server is configured for HTTPS, either served
+// itself or through the public URL, or the request came in over TLS.
+// Proxy headers are ignored as clients can set them as well.
+func (s server) newCookie(r *http.Request, name, value, path string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Expires:  expires,
 		HttpOnly: true,
-		Path:     "/",
-	})
+		SameSite: http.SameSiteLaxMode,
+		Secure:   s.secureCookies || r.TLS != nil,
+		Path:     path,
+	}
+}
+
+// Override the cookie with an expired one, effectively deleting it.
+func (s server) expiredCookie(r *http.Request, name, path string) *http.Cookie {
+	return s.newCookie(r, name, "", path, time.Now().Add(time.Duration(-1*time.Hour)))
 }
@@ -15,7 +15,7 @@ func (s server) handleGetCategories(r *http.Request) ([]model.TodoCategory, rout
 		return nil, internalError
 	}
 
-	cats, err := s.todos.GetAllCategories(claims.ID)
+	cats, err := s.todos.GetAllCategories(r.Context(), claims.ID)
 	if err != nil {
 		return nil, internalErrorCause(err)
 	}
@@ -35,7 +35,11 @@ func (s server) handlePostCategory(r *http.Request) (int64, router.HttpStatus) {
 		return 0, badRequestCause(errors.New("missing field 'name'"))
 	}
 
-	id, err := s.todos.CreateCategory(name, claims.ID)
+	if err := router.Validate(model.CreateCategory{Name: name}); err.Err() {
+		return 0, badRequestCause(err)
+	}
+
+	id, err := s.todos.CreateCategory(r.Context(), name, claims.ID)
 	if err != nil {
 		return 0, internalErrorCause(err)
 	}
@@ -43,7 +47,13 @@ func (s server) handlePostCategory(r *http.Request) (int64, router.HttpStatus) {
 	return id, statusOK
 }
 
-// PATCH /api/todo/category/{id}?name={name}
+// Renames the category. With the optional version parameter the rename
+// fails with 409 if the category has been changed in the meantime.
+//
+// Instead of the parameters the body may carry a JSON Merge Patch or JSON
+// Patch, which is validated like a new category and may only change the name.
+//
+// PATCH /api/todo/category/{id}?name={name}&version={version}
 func (s server) handlePatchCategory(r *http.Request) router.HttpStatus {
 	claims, ok := router.GetClaims(r)
 	if !ok {
@@ -56,16 +66,53 @@ func (s server) handlePatchCategory(r *http.Request) router.HttpStatus {
 		return badRequestCause(errors.New("incorrect 'id'"))
 	}
 
+	patch, status := readPatch(r)
+	if status.Err != nil {
+		return status
+	}
+	if patch.present() {
+		_, err = s.todos.PatchCategory(r.Context(), categoryID, claims.ID, func(cat *model.TodoCategory) error {
+			status = patchCategoryWith(cat, patch)
+			return status.Err
+		})
+		if status.Err != nil {
+			return status
+		}
+		return updateStatus(categoryID, err)
+	}
+
 	name := r.URL.Query().Get("name")
 	if name == "" {
 		return badRequestCause(errors.New("missing field 'name'"))
 	}
+	if err := router.Validate(model.CreateCategory{Name: name}); err.Err() {
+		return badRequestCause(err)
+	}
 
-	err = s.todos.UpdateCategory(name, categoryID, claims.ID)
-	if err != nil {
-		return internalErrorCause(err)
+	var version int64
+	if raw := r.URL.Query().Get("version"); raw != "" {
+		version, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return badRequestCause(errors.New("incorrect 'version'"))
+		}
 	}
 
+	err = s.todos.UpdateCategory(r.Context(), name, categoryID, claims.ID, version)
+	return updateStatus(categoryID, err)
+}
+
+func patchCategoryWith(cat *model.TodoCategory, patch patchBody) router.HttpStatus {
+	var changed model.TodoCategory
+	if status := patch.apply(cat, &changed); status.Err != nil {
+		return status
+	}
+	if changed.ID != cat.ID || changed.Version != cat.Version {
+		return badRequestCause(errors.New("only the name can be changed"))
+	}
+	if err := router.Validate(model.CreateCategory{Name: changed.Name}); err.Err() {
+		return badRequestCause(err)
+	}
+	cat.Name = changed.Name
 	return statusOK
 }
 
@@ -85,7 +132,7 @@ func (s server) handleDeleteCategory(r *http.Request) router.HttpStatus {
 		return badRequestCause(errors.New("cannot delete this category"))
 	}
 
-	err = s.todos.DeleteCategory(categoryID, claims.ID)
+	err = s.todos.DeleteCategory(r.Context(), categoryID, claims.ID)
 	if err != nil {
 		return internalErrorCause(err)
 	}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"check42/api/router"
+	"context"
+	"net/http"
+	"time"
+)
+
+// Reports whether the dependencies needed to serve requests are available.
+type ReadinessCheck func(ctx context.Context) error
+
+type health struct {
+	Status string `json:"status"`
+}
+
+// Liveness probe. Answers as long as the server handles requests at all.
+//
+// GET /healthz
+func handleHealth(r *http.Request) (health, router.HttpStatus) {
+	return health{Status: "ok"}, statusOK
+}
+
+// Readiness probe. Fails with 503 while the database is unreachable so
+// no traffic is sent to this instance.
+//
+// GET /readyz
+func handleReady(ready ReadinessCheck) router.ProcessFunc[health] {
+	return func(r *http.Request) (health, router.HttpStatus) {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+		if err := ready(ctx); err != nil {
+			return health{}, router.HttpStatus{Code: http.StatusServiceUnavailable, Err: err}
+		}
+		return health{Status: "ok"}, statusOK
+	}
+}
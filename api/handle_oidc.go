@@ -0,0 +1,210 @@
+package api
+
+import (
+	"check42/api/oidc"
+	"check42/api/router"
+	"check42/config"
+	"check42/model"
+	"check42/store/stores"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+const oidcStateCookie = "oidc_state"
+
+// Redirect the user agent to the provider's authorization endpoint.
+// State, nonce and PKCE verifier are kept in a short lived signed cookie
+// until the provider redirects back to the callback.
+//
+// GET /auth/oidc/{provider}
+func (s server) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := s.oidc[r.PathValue("provider")]
+	if !ok {
+		fail(w, r, http.StatusNotFound, "unknown provider")
+		return
+	}
+
+	state, nonce, verifier := oidc.RandomString(), oidc.RandomString(), oidc.RandomString()
+	redirect, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		router.Logger(r).Error("Identity provider unavailable", "provider", provider.Name, "err", err)
+		fail(w, r, http.StatusBadGateway, "identity provider unavailable")
+		return
+	}
+
+	expires := time.Now().Add(10 * time.Minute)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"provider": provider.Name,
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"exp":      jwt.NumericDate{Time: expires},
+	})
+	signed, err := token.SignedString(s.auth.jwtSecret)
+	if err != nil {
+		fail(w, r, http.StatusInternalServerError, "internal error")
+		return
+	}
+	http.SetCookie(w, s.newCookie(r, oidcStateCookie, signed, "/auth/oidc/", expires))
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// Redeem the authorization code, find or provision the matching user
+// and start a regular session.
+//
+// GET /auth/oidc/{provider}/callback?code={code}&state={state}
+func (s server) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := s.oidc[r.PathValue("provider")]
+	if !ok {
+		fail(w, r, http.StatusNotFound, "unknown provider")
+		return
+	}
+	if msg := r.URL.Query().Get("error"); msg != "" {
+		fail(w, r, http.StatusUnauthorized, "provider returned error")
+		return
+	}
+
+	c, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		fail(w, r, http.StatusBadRequest, "missing login state")
+		return
+	}
+	http.SetCookie(w, s.expiredCookie(r, oidcStateCookie, "/auth/oidc/"))
+	state, err := parseOIDCState(c.Value, s.auth.jwtSecret)
+	if err != nil || state["provider"] != provider.Name || state["state"] != r.URL.Query().Get("state") {
+		fail(w, r, http.StatusBadRequest, "invalid login state")
+		return
+	}
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		fail(w, r, http.StatusBadRequest, "missing field 'code'")
+		return
+	}
+	identity, err := provider.Exchange(r.Context(), code, state["verifier"], state["nonce"])
+	if err != nil {
+		router.Logger(r).Warn("OIDC login failed", "provider", provider.Name, "err", err)
+		fail(w, r, http.StatusUnauthorized, "could not verify identity")
+		return
+	}
+
+	user, err := s.resolveIdentity(r.Context(), provider.Name, identity)
+	if err == errUnverifiedAccount {
+		fail(w, r, http.StatusConflict, "an account with this email exists, verify its email before signing in with "+provider.Name)
+		return
+	}
+	if err != nil {
+		router.Logger(r).Warn("OIDC login failed", "provider", provider.Name, "err", err)
+		fail(w, r, http.StatusUnauthorized, "could not sign in")
+		return
+	}
+
+	if user.Disabled {
+		fail(w, r, http.StatusForbidden, "account is disabled")
+		return
+	}
+	if user.TOTPEnabled {
+		// the provider vouches for the identity, not for the second factor
+		if err := s.startPendingLogin(w, r, user.ID); err != nil {
+			fail(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+		http.Redirect(w, r, "/?2fa=required", http.StatusFound)
+		return
+	}
+	if err := s.startSession(w, r, user.Claims()); err != nil {
+		fail(w, r, http.StatusInternalServerError, "internal error")
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// An unverified local account may have been registered by someone else in
+// anticipation of the owner's first external login, so it is never linked.
+var errUnverifiedAccount = errors.New("local account with the same email is not verified")
+
+// Find the user an external identity belongs to.
+// Unknown identities are linked to the user with the same verified email
+// or provisioned as a new user. Both the provider and the local account
+// have to vouch for the email.
+func (s server) resolveIdentity(ctx context.Context, provider string, id oidc.Identity) (model.User, error) {
+	user, err := s.users.GetUserByIdentity(ctx, provider, id.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if err != stores.ErrNotFound {
+		return model.User{}, err
+	}
+
+	if id.Email == "" || !id.EmailVerified {
+		return model.User{}, errors.New("provider did not assert a verified email")
+	}
+
+	user, err = s.users.GetUserByEmail(ctx, id.Email)
+	if err == nil {
+		if !user.EmailVerified {
+			return model.User{}, errUnverifiedAccount
+		}
+		if err := s.users.LinkIdentity(ctx, user.ID, provider, id.Subject); err != nil {
+			return model.User{}, err
+		}
+		return user, nil
+	}
+	if err != stores.ErrNotFound {
+		return model.User{}, err
+	}
+
+	name := id.Username
+	if name == "" {
+		name = id.Email
+	}
+	user, err = s.users.CreateExternalUser(ctx, name, id.Email, provider, id.Subject)
+	if err == stores.ErrUsernameTaken && name != id.Email {
+		// emails are unique as well and make for a safe fallback
+		user, err = s.users.CreateExternalUser(ctx, id.Email, id.Email, provider, id.Subject)
+	}
+	return user, err
+}
+
+func parseOIDCState(payload string, secret []byte) (map[string]string, error) {
+	raw := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(payload, raw, func(t *jwt.Token) (any, error) {
+		if alg := t.Method.Alg(); alg != jwt.SigningMethodHS256.Alg() {
+			return nil, errors.New("incorrect signing method: " + alg)
+		}
+		return secret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	state := make(map[string]string, 4)
+	for _, key := range []string{"provider", "state", "nonce", "verifier"} {
+		val, ok := raw[key].(string)
+		if !ok || val == "" {
+			return nil, fmt.Errorf("invalid field '%s'", key)
+		}
+		state[key] = val
+	}
+	return state, nil
+}
+
+// Create the configured OIDC providers by name.
+func oidcProviders(configured map[string]config.Provider) map[string]*oidc.Provider {
+	providers := make(map[string]*oidc.Provider, len(configured))
+	for name, p := range configured {
+		providers[name] = oidc.NewProvider(oidc.Config{
+			Name:         name,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  p.RedirectURL,
+			Scopes:       p.Scopes,
+		})
+	}
+	return providers
+}
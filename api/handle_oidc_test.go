@@ -0,0 +1,368 @@
+package api
+
+import (
+	"check42/api/oidc"
+	"check42/api/router"
+	"check42/model"
+	"check42/store/stores"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	testClientID     = "check42"
+	testClientSecret = "client-secret"
+)
+
+// Identity provider serving discovery, key set and token endpoint. The
+// authorization endpoint is skipped, tests hand out codes via authorize.
+type testIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	codes  map[string]authRequest
+	claims jwt.MapClaims // added to every ID token
+}
+
+type authRequest struct {
+	nonce, challenge string
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{key: key, codes: make(map[string]authRequest)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"use": "sig",
+			"n":   b64.EncodeToString(key.N.Bytes()),
+			"e":   b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", idp.handleToken)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *testIdP) handleToken(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	if id != testClientID || secret != testClientSecret || r.PostFormValue("grant_type") != "authorization_code" {
+		http.Error(w, "invalid client", http.StatusUnauthorized)
+		return
+	}
+	idp.mu.Lock()
+	req, ok := idp.codes[r.PostFormValue("code")]
+	delete(idp.codes, r.PostFormValue("code"))
+	claims := jwt.MapClaims{}
+	for k, v := range idp.claims {
+		claims[k] = v
+	}
+	idp.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != req.challenge {
+		http.Error(w, "invalid grant", http.StatusBadRequest)
+		return
+	}
+	claims["iss"] = idp.URL
+	claims["aud"] = testClientID
+	claims["exp"] = time.Now().Add(time.Minute).Unix()
+	if _, ok := claims["nonce"]; !ok {
+		claims["nonce"] = req.nonce
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+}
+
+// Act as the authorization endpoint: remember nonce and PKCE challenge of
+// the redirect and hand out a code for them.
+func (idp *testIdP) authorize(t *testing.T, redirect string) (code, state string) {
+	u, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != testClientID || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization request %s", redirect)
+	}
+	code = oidc.RandomString()
+	idp.mu.Lock()
+	idp.codes[code] = authRequest{nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+	idp.mu.Unlock()
+	return code, q.Get("state")
+}
+
+type fakeUsers struct {
+	stores.UserStore
+	users      []model.User
+	identities map[string]int64
+	recovery   map[string]bool // unused recovery code hashes
+}
+
+func (f *fakeUsers) GetUserByID(_ context.Context, id int) (model.User, error) {
+	if id < 1 || id > len(f.users) {
+		return model.User{}, stores.ErrNotFound
+	}
+	return f.users[id-1], nil
+}
+
+func (f *fakeUsers) UseRecoveryCode(_ context.Context, _ int64, hash string) (bool, error) {
+	unused := f.recovery[hash]
+	delete(f.recovery, hash)
+	return unused, nil
+}
+
+func (f *fakeUsers) GetUserByIdentity(_ context.Context, provider, subject string) (model.User, error) {
+	if id, ok := f.identities[provider+":"+subject]; ok {
+		return f.users[id-1], nil
+	}
+	return model.User{}, stores.ErrNotFound
+}
+
+func (f *fakeUsers) GetUserByEmail(_ context.Context, email string) (model.User, error) {
+	for _, u := range f.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return model.User{}, stores.ErrNotFound
+}
+
+func (f *fakeUsers) LinkIdentity(_ context.Context, userID int64, provider, subject string) error {
+	f.identities[provider+":"+subject] = userID
+	return nil
+}
+
+func (f *fakeUsers) CreateExternalUser(_ context.Context, name, email, provider, subject string) (model.User, error) {
+	for _, u := range f.users {
+		if u.Name == name {
+			return model.User{}, stores.ErrUsernameTaken
+		}
+	}
+	user := model.User{ID: int64(len(f.users) + 1), Name: name, Email: email, EmailVerified: true, Role: model.RoleUser}
+	f.users = append(f.users, user)
+	f.identities[provider+":"+subject] = user.ID
+	return user, nil
+}
+
+type oidcTest struct {
+	t     *testing.T
+	idp   *testIdP
+	users *fakeUsers
+	s     server
+}
+
+func newOIDCTest(t *testing.T, users ...model.User) *oidcTest {
+	idp := newTestIdP(t)
+	fake := &fakeUsers{users: users, identities: make(map[string]int64), recovery: make(map[string]bool)}
+	return &oidcTest{
+		t:     t,
+		idp:   idp,
+		users: fake,
+		s: server{
+			users:         fake,
+			auth:          ApiAuthority{jwtSecret: []byte("jwt-secret")},
+			pendingLogins: router.NewMemoryAttemptStore(pendingLoginPolicy),
+			oidc: map[string]*oidc.Provider{"idp": oidc.NewProvider(oidc.Config{
+				Name:         "idp",
+				Issuer:       idp.URL,
+				ClientID:     testClientID,
+				ClientSecret: testClientSecret,
+				RedirectURL:  "http://localhost/auth/oidc/idp/callback",
+			})},
+		},
+	}
+}
+
+// Run login and callback with the identity the provider asserts in claims.
+func (o *oidcTest) signIn(claims jwt.MapClaims) *httptest.ResponseRecorder {
+	o.idp.mu.Lock()
+	o.idp.claims = claims
+	o.idp.mu.Unlock()
+
+	r := httptest.NewRequest(http.MethodGet, "/auth/oidc/idp", nil)
+	r.SetPathValue("provider", "idp")
+	rec := httptest.NewRecorder()
+	o.s.handleOIDCLogin(rec, r)
+	if rec.Code != http.StatusFound {
+		o.t.Fatalf("login: got %d %s", rec.Code, rec.Body)
+	}
+	code, state := o.idp.authorize(o.t, rec.Header().Get("Location"))
+
+	r = httptest.NewRequest(http.MethodGet, "/auth/oidc/idp/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil)
+	r.SetPathValue("provider", "idp")
+	for _, c := range rec.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	rec = httptest.NewRecorder()
+	o.s.handleOIDCCallback(rec, r)
+	return rec
+}
+
+// Name in the session cookie set by the response, empty if there is none.
+func (o *oidcTest) sessionUser(rec *httptest.ResponseRecorder) string {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name != "jwt" {
+			continue
+		}
+		claims := jwt.MapClaims{}
+		if _, err := jwt.ParseWithClaims(c.Value, claims, func(*jwt.Token) (any, error) { return o.s.auth.jwtSecret, nil }); err != nil {
+			o.t.Fatalf("invalid session cookie: %v", err)
+		}
+		name, _ := claims["sub"].(string)
+		return name
+	}
+	return ""
+}
+
+func TestOIDCProvisionsNewUser(t *testing.T) {
+	o := newOIDCTest(t)
+	rec := o.signIn(jwt.MapClaims{"sub": "42", "email": "alice@example.com", "email_verified": true, "preferred_username": "alice"})
+	if rec.Code != http.StatusFound || o.sessionUser(rec) != "alice" {
+		t.Fatalf("got %d %s", rec.Code, rec.Body)
+	}
+
+	// the second login finds the identity, even with another email
+	rec = o.signIn(jwt.MapClaims{"sub": "42", "email": "alice@other.com", "email_verified": true})
+	if rec.Code != http.StatusFound || o.sessionUser(rec) != "alice" || len(o.users.users) != 1 {
+		t.Errorf("second login: got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOIDCLinksVerifiedAccount(t *testing.T) {
+	o := newOIDCTest(t, model.User{ID: 1, Name: "bob", Email: "bob@example.com", EmailVerified: true})
+	rec := o.signIn(jwt.MapClaims{"sub": "7", "email": "bob@example.com", "email_verified": "true", "preferred_username": "bobby"})
+	if rec.Code != http.StatusFound || o.sessionUser(rec) != "bob" {
+		t.Fatalf("got %d %s", rec.Code, rec.Body)
+	}
+	if o.users.identities["idp:7"] != 1 {
+		t.Errorf("identity not linked, got %v", o.users.identities)
+	}
+}
+
+func TestOIDCAsksForSecondFactor(t *testing.T) {
+	o := newOIDCTest(t, model.User{ID: 1, Name: "grace", Email: "grace@example.com", EmailVerified: true, TOTPEnabled: true, TOTPSecret: "GEZDGNBVGY3TQOJQ"})
+	o.users.recovery[hashRecoveryCode("abcde-fghij-klmno")] = true
+	rec := o.signIn(jwt.MapClaims{"sub": "9", "email": "grace@example.com", "email_verified": true})
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/?2fa=required" || o.sessionUser(rec) != "" {
+		t.Fatalf("got %d to %q, want a redirect without session", rec.Code, rec.Header().Get("Location"))
+	}
+
+	var pending *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == pendingLoginCookie {
+			pending = c
+		}
+	}
+	if pending == nil {
+		t.Fatal("missing pending login cookie")
+	}
+	secondFactor := func(code string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/auth/2fa?code="+code, nil)
+		r.AddCookie(pending)
+		rec := httptest.NewRecorder()
+		o.s.handleLogin2FA(rec, r)
+		return rec
+	}
+	if rec := secondFactor("000000"); rec.Code != http.StatusUnauthorized || o.sessionUser(rec) != "" {
+		t.Errorf("wrong code: got %d", rec.Code)
+	}
+	if rec := secondFactor("abcde-fghij-klmno"); rec.Code != http.StatusOK || o.sessionUser(rec) != "grace" {
+		t.Errorf("recovery code: got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOIDCRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		users  []model.User
+		claims jwt.MapClaims
+		code   int
+	}{
+		{"unverified local account", []model.User{{ID: 1, Name: "carol", Email: "carol@example.com"}},
+			jwt.MapClaims{"sub": "1", "email": "carol@example.com", "email_verified": true}, http.StatusConflict},
+		{"unverified provider email", nil,
+			jwt.MapClaims{"sub": "1", "email": "dave@example.com", "email_verified": false}, http.StatusUnauthorized},
+		{"missing email", nil,
+			jwt.MapClaims{"sub": "1", "email_verified": true}, http.StatusUnauthorized},
+		{"nonce mismatch", nil,
+			jwt.MapClaims{"sub": "1", "email": "erin@example.com", "email_verified": true, "nonce": "replayed"}, http.StatusUnauthorized},
+		{"disabled user", []model.User{{ID: 1, Name: "frank", Email: "frank@example.com", EmailVerified: true, Disabled: true}},
+			jwt.MapClaims{"sub": "1", "email": "frank@example.com", "email_verified": true}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		o := newOIDCTest(t, tt.users...)
+		rec := o.signIn(tt.claims)
+		if rec.Code != tt.code || o.sessionUser(rec) != "" {
+			t.Errorf("%s: got %d %s, want %d without session", tt.name, rec.Code, rec.Body, tt.code)
+		}
+		// a disabled account keeps its link, it just can't sign in
+		if len(o.users.identities) != 0 && tt.code != http.StatusForbidden {
+			t.Errorf("%s: identities linked %v", tt.name, o.users.identities)
+		}
+	}
+}
+
+func TestOIDCCallbackChecksState(t *testing.T) {
+	o := newOIDCTest(t)
+	r := httptest.NewRequest(http.MethodGet, "/auth/oidc/idp", nil)
+	r.SetPathValue("provider", "idp")
+	rec := httptest.NewRecorder()
+	o.s.handleOIDCLogin(rec, r)
+	code, state := o.idp.authorize(t, rec.Header().Get("Location"))
+	cookies := rec.Result().Cookies()
+
+	callback := func(state string, withCookies bool) int {
+		r := httptest.NewRequest(http.MethodGet, "/auth/oidc/idp/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil)
+		r.SetPathValue("provider", "idp")
+		if withCookies {
+			for _, c := range cookies {
+				r.AddCookie(c)
+			}
+		}
+		rec := httptest.NewRecorder()
+		o.s.handleOIDCCallback(rec, r)
+		return rec.Code
+	}
+	if got := callback(state, false); got != http.StatusBadRequest {
+		t.Errorf("without state cookie: got %d", got)
+	}
+	if got := callback("forged", true); got != http.StatusBadRequest {
+		t.Errorf("with forged state: got %d", got)
+	}
+}
@@ -4,64 +4,61 @@ import (
 	"check42/api/router"
 	"check42/model"
 	"check42/store/stores"
-	"encoding/json"
 	"errors"
 	"net/http"
-	"strconv"
 )
 
-// GET /api/todo
-func (s server) handleGetTodos(r *http.Request) ([]model.Todo, router.HttpStatus) {
-	claims, ok := router.GetClaims(r)
-	if !ok {
-		return nil, router.HttpStatus{Code: http.StatusUnauthorized, Err: errors.New("insufficient claims")}
-	}
-	ts, err := s.todos.GetAllTodos(claims.ID)
-	if err != nil {
-		return nil, router.HttpStatus{Code: http.StatusInternalServerError, Err: err}
-	}
-	return ts, router.HttpStatus{Code: 200, Err: nil}
+type todoID struct {
+	ID int64 `path:"id"`
 }
 
-// POST /api/todo
-func (s server) handlePostTodo(r *http.Request) (int64, router.HttpStatus) {
-	claims, ok := router.GetClaims(r)
-	if !ok {
-		return 0, internalError
-	}
+type postTodo struct {
+	Todo model.CreateTodo `body:"json"`
+}
 
-	var todo model.CreateTodo
-	err := json.NewDecoder(r.Body).Decode(&todo)
+type putTodo struct {
+	ID   int64      `path:"id"`
+	Todo model.Todo `body:"json"`
+}
+
+type moveTodos struct {
+	ID   int64           `path:"id"`
+	Move model.MoveTodos `body:"json"`
+}
+
+type patchTodo struct {
+	ID   int64   `path:"id"`
+	Done *bool   `query:"done"`
+	Text *string `query:"text"`
+}
+
+// GET /api/todo
+func (s server) handleGetTodos(r *http.Request, claims *router.Claims, _ struct{}) (model.TodoList, router.HttpStatus) {
+	ts, err := s.todos.GetAllTodos(r.Context(), claims.ID)
 	if err != nil {
-		return 0, router.HttpStatus{Code: http.StatusBadRequest, Err: err}
-	}
-	if err := todo.ValidateNew(); err.Err() {
-		return 0, router.HttpStatus{Code: http.StatusBadRequest, Err: err}
+		return nil, internalErrorCause(err)
 	}
+	return ts, statusOK
+}
 
+// POST /api/todo
+func (s server) handlePostTodo(r *http.Request, claims *router.Claims, in postTodo) (int64, router.HttpStatus) {
+	todo := in.Todo
 	todo.Owner = claims.ID
-	id, err := s.todos.CreateTodo(todo)
+	id, err := s.todos.CreateTodo(r.Context(), todo)
 	if err != nil {
-		return 0, router.HttpStatus{Code: 500, Err: err}
+		return 0, internalErrorCause(err)
 	}
+	s.metrics.created.Inc()
+	s.metrics.done(false, todo.Done)
 	return id, statusCreated
 }
 
 // GET /api/todo/{id}
-func (s server) handleGetTodo(r *http.Request) (model.Todo, router.HttpStatus) {
-	claims, ok := router.GetClaims(r)
-	if !ok {
-		return model.Todo{}, internalError
-	}
-
-	pathValue := r.PathValue("id")
-	id, err := strconv.ParseInt(pathValue, 10, 64)
-	if err != nil {
-		return model.Todo{}, badRequestCause(err)
-	}
-	td, err := s.todos.GetTodo(id, claims.ID)
+func (s server) handleGetTodo(r *http.Request, claims *router.Claims, in todoID) (model.Todo, router.HttpStatus) {
+	td, err := s.todos.GetTodo(r.Context(), in.ID, claims.ID)
 	if err == stores.ErrNotFound {
-		return model.Todo{}, notFound(id)
+		return model.Todo{}, notFound(in.ID)
 	}
 	if err != nil {
 		return model.Todo{}, internalErrorCause(err)
@@ -69,79 +66,166 @@ func (s server) handleGetTodo(r *http.Request) (model.Todo, router.HttpStatus) {
 	return td, statusOK
 }
 
+// Conditional deletes only succeed if the todo is still the version the
+// preconditions were checked against.
+//
 // DELETE /api/todo/{id}
-func (s server) handleDeleteTodo(r *http.Request) router.HttpStatus {
-	claims, ok := router.GetClaims(r)
-	if !ok {
-		return internalError
+func (s server) handleDeleteTodo(r *http.Request, claims *router.Claims, in todoID) router.HttpStatus {
+	current, status := s.todoForUpdate(r, in.ID, claims.ID)
+	if status.Err != nil {
+		return status
 	}
-
-	pathValue := r.PathValue("id")
-	id, err := strconv.ParseInt(pathValue, 10, 64)
-	if err != nil {
-		return badRequestCause(err)
+	var version int64
+	if router.HasPreconditions(r) {
+		version = current.Version
 	}
-	err = s.todos.DeleteTodo(id, claims.ID)
-	if err != nil {
-		return internalErrorCause(err)
+	err := s.todos.DeleteTodo(r.Context(), in.ID, claims.ID, version)
+	if err == stores.ErrConflict {
+		// changed after the preconditions were checked
+		return preconditionFailed
 	}
-	return router.HttpStatus{Code: http.StatusOK, Err: nil}
+	return updateStatus(in.ID, err)
 }
 
+// Replaces text, done and category of the todo, which are validated like a
+// new todo. If the body carries a version, it has to match the stored one.
+//
 // PUT /api/todo/{id}
-func (s server) handlePutTodo(r *http.Request) router.HttpStatus {
-	claims, ok := router.GetClaims(r)
-	if !ok {
-		return internalError
-	}
-
-	pathValue := r.PathValue("id")
-	id, err := strconv.ParseInt(pathValue, 10, 64)
-	if err != nil {
+func (s server) handlePutTodo(r *http.Request, claims *router.Claims, in putTodo) router.HttpStatus {
+	current, status := s.todoForUpdate(r, in.ID, claims.ID)
+	if status.Err != nil {
+		return status
+	}
+	todo := in.Todo
+	valid := model.CreateTodo{Text: todo.Text, Done: todo.Done, Category: todo.Category}
+	if err := valid.ValidateNew(); err.Err() {
 		return badRequestCause(err)
 	}
-	var t model.Todo
-	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-		return badRequestCause(err)
+	if todo.Version == 0 {
+		todo.Version = current.Version
 	}
+	err := s.todos.UpdateTodo(r.Context(), in.ID, claims.ID, todo)
+	if err == stores.ErrConflict && router.HasPreconditions(r) {
+		// changed after the preconditions were checked
+		return preconditionFailed
+	}
+	if err == nil {
+		s.metrics.done(current.Done, todo.Done)
+	}
+	return updateStatus(in.ID, err)
+}
 
-	if err := s.todos.UpdateTodo(id, claims.ID, t); err != nil {
+// Moves the todo and the ones listed in the body into another category.
+// Either all of them are moved or, if one of them does not exist, none.
+//
+// POST /api/todo/{id}/move
+func (s server) handleMoveTodos(r *http.Request, claims *router.Claims, in moveTodos) router.HttpStatus {
+	todoIDs := append([]int64{in.ID}, in.Move.Todos...)
+	err := s.todos.MoveTodos(r.Context(), in.Move.Category, claims.ID, todoIDs)
+	switch {
+	case err == stores.ErrNotFound:
+		return router.HttpStatus{Code: http.StatusNotFound, Err: errors.New("not all of the todos were found")}
+	case err == stores.ErrNoCategory:
+		return badRequestCause(err)
+	case err != nil:
 		return internalErrorCause(err)
 	}
-	return router.HttpStatus{Code: http.StatusOK, Err: nil}
+	return statusOK
 }
 
-// Updates the fields provided in the URL parameters.
-// Options are done={bool} and text={string}.
-// All other fields are preserved.
+// Updates the todo with the JSON Merge Patch (RFC 7396) or JSON Patch
+// (RFC 6902) in the body, chosen by its content type. The result is validated
+// like a new todo, only text, done and category can be changed.
+//
+// Without such a body the fields provided in the URL parameters are updated.
+// Options are done={bool} and text={string}, all other fields are preserved.
 //
 // PATCH /api/todo/{id}
-func (s server) handlePatchTodo(r *http.Request) router.HttpStatus {
-	claims, ok := router.GetClaims(r)
-	if !ok {
-		return internalError
-	}
+func (s server) handlePatchTodo(r *http.Request, claims *router.Claims, in patchTodo) router.HttpStatus {
+	patch, status := readPatch(r)
+	if status.Err != nil {
+		return status
+	}
+	// the todo is locked while fn runs, so nothing can change it in between
+	var wasDone bool
+	todo, err := s.todos.PatchTodo(r.Context(), in.ID, claims.ID, func(todo *model.Todo) error {
+		wasDone = todo.Done
+		status = patchTodoWith(r, todo, patch, in)
+		return status.Err
+	})
+	if status.Err != nil {
+		return status
+	}
+	if err == nil {
+		s.metrics.done(wasDone, todo.Done)
+	}
+	return updateStatus(in.ID, err)
+}
 
-	pathValue := r.PathValue("id")
-	id, err := strconv.ParseInt(pathValue, 10, 64)
-	if err != nil {
+func patchTodoWith(r *http.Request, todo *model.Todo, patch patchBody, in patchTodo) router.HttpStatus {
+	if status := router.CheckPreconditions(r, todo.Validators()); status.Err != nil {
+		return status
+	}
+	changed := *todo
+	if patch.present() {
+		// members the patch removes must not keep their old value
+		changed = model.Todo{}
+		if status := patch.apply(todo, &changed); status.Err != nil {
+			return status
+		}
+		if changed.ID != todo.ID || changed.Owner != todo.Owner || changed.Version != todo.Version ||
+			!changed.Created.Equal(todo.Created) || !changed.Updated.Equal(todo.Updated) {
+			return badRequestCause(errors.New("only text, done and category can be changed"))
+		}
+		// the category is referenced by id, its other fields belong to the category itself
+		if changed.Category.ID == todo.Category.ID && changed.Category != todo.Category {
+			return badRequestCause(errors.New("categories can only be changed through their id"))
+		}
+	} else {
+		if in.Done != nil {
+			changed.Done = *in.Done
+		}
+		if in.Text != nil {
+			changed.Text = *in.Text
+		}
+	}
+	valid := model.CreateTodo{Text: changed.Text, Done: changed.Done, Category: changed.Category}
+	if err := valid.ValidateNew(); err.Err() {
 		return badRequestCause(err)
 	}
+	todo.Text = changed.Text
+	todo.Done = changed.Done
+	todo.Category = model.TodoCategory{ID: changed.Category.ID}
+	return statusOK
+}
 
-	todo, err := s.todos.GetTodo(id, claims.ID)
+// Load the todo about to be changed and check the request's If-Match or
+// If-Unmodified-Since against it.
+func (s server) todoForUpdate(r *http.Request, todoID, userID int64) (model.Todo, router.HttpStatus) {
+	todo, err := s.todos.GetTodo(r.Context(), todoID, userID)
+	if err == stores.ErrNotFound {
+		return model.Todo{}, notFound(todoID)
+	}
 	if err != nil {
-		return internalError
+		return model.Todo{}, internalErrorCause(err)
 	}
-	if val := r.URL.Query().Get("done"); val != "" {
-		done, err := strconv.ParseBool(val)
-		if err != nil {
-			return badRequestCause(err)
-		}
-		todo.Done = done
+	if status := router.CheckPreconditions(r, todo.Validators()); status.Err != nil {
+		return model.Todo{}, status
 	}
-	if val := r.URL.Query().Get("text"); val != "" {
-		todo.Text = val
+	return todo, statusOK
+}
+
+// Status for the outcome of a compare and swap update.
+func updateStatus(id int64, err error) router.HttpStatus {
+	switch {
+	case err == stores.ErrNotFound:
+		return notFound(id)
+	case err == stores.ErrConflict:
+		return conflict(id)
+	case err == stores.ErrNoCategory:
+		return badRequestCause(err)
+	case err != nil:
+		return internalErrorCause(err)
 	}
-	s.todos.UpdateTodo(todo.ID, todo.Owner, todo)
-	return router.HttpStatus{Code: http.StatusOK, Err: nil}
+	return statusOK
 }
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"check42/api/router"
+	"check42/model"
+	"check42/store/stores"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// racingTodos hands out the todo, but another request always changes it
+// before the update is written.
+type racingTodos struct {
+	stores.TodoStore
+	todo model.Todo
+}
+
+func (f racingTodos) GetTodo(ctx context.Context, todoID, userID int64) (model.Todo, error) {
+	return f.todo, nil
+}
+
+func (f racingTodos) UpdateTodo(ctx context.Context, todoID, userID int64, update model.Todo) error {
+	return stores.ErrConflict
+}
+
+func TestPutTodoRace(t *testing.T) {
+	todo := model.Todo{ID: 1, Owner: 2, Text: "milk", Version: 3}
+	s := server{todos: racingTodos{todo: todo}}
+	in := putTodo{ID: todo.ID, Todo: model.Todo{Text: "bread"}}
+
+	tests := []struct {
+		name    string
+		ifMatch string
+		code    int
+	}{
+		{"If-Match", todo.Validators().ETag, http.StatusPreconditionFailed},
+		{"no preconditions", "", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPut, "/api/todo/1", nil)
+		if tt.ifMatch != "" {
+			r.Header.Set("If-Match", tt.ifMatch)
+		}
+		status := s.handlePutTodo(r, &router.Claims{ID: todo.Owner}, in)
+		if status.Code != tt.code {
+			t.Errorf("%s: got status %d, want %d", tt.name, status.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"check42/api/router"
+	"check42/model"
+	"check42/store/password"
+	"check42/store/stores"
+	"errors"
+	"net/http"
+)
+
+// GET /api/me
+func (s server) handleGetMe(r *http.Request) (model.Profile, router.HttpStatus) {
+	user, status := s.currentUser(r)
+	if status.Err != nil {
+		return model.Profile{}, status
+	}
+	return user.Profile(), statusOK
+}
+
+// Change name and/or email. A new email has to be verified before the next login.
+// Changing the email requires the current password in the 'password' field, so a
+// stolen session can't redirect password resets to another address. Users whose
+// password hash predates argon2id also need it to change their name, as it is
+// part of the hash.
+//
+// PATCH /api/me
+func (s server) handlePatchMe(r *http.Request, update model.UpdateProfile) (model.Profile, router.HttpStatus) {
+	user, status := s.currentUser(r)
+	if status.Err != nil {
+		return model.Profile{}, status
+	}
+
+	name, email, pw := user.Name, user.Email, ""
+	if update.Name != nil && *update.Name != user.Name {
+		if password.IsLegacy(user.PasswordHash) {
+			if !s.auth.checkPassword(r.Context(), user, update.Password) {
+				return model.Profile{}, badRequestCause(errors.New("incorrect 'password'"))
+			}
+			pw = update.Password
+		}
+		name = *update.Name
+	}
+	if update.Email != nil && *update.Email != user.Email {
+		if !s.auth.checkPassword(r.Context(), user, update.Password) {
+			return model.Profile{}, badRequestCause(errors.New("incorrect 'password'"))
+		}
+		email = *update.Email
+	}
+
+	err := s.users.UpdateProfile(r.Context(), user.ID, name, email, pw)
+	switch err {
+	case nil:
+	case stores.ErrUsernameTaken, stores.ErrEmailTaken:
+		return model.Profile{}, badRequestCause(err)
+	default:
+		return model.Profile{}, internalErrorCause(err)
+	}
+
+	updated, err := s.users.GetUserByID(r.Context(), int(user.ID))
+	if err != nil {
+		return model.Profile{}, internalErrorCause(err)
+	}
+	if updated.Email != user.Email {
+		if err := s.sendVerification(r.Context(), updated); err != nil {
+			router.Logger(r).Error("Sending verification mail failed", "err", err)
+		}
+	}
+	return updated.Profile(), statusOK
+}
+
+// POST /api/me/password
+func (s server) handleChangePassword(r *http.Request, change model.PasswordChange) router.HttpStatus {
+	user, status := s.currentUser(r)
+	if status.Err != nil {
+		return status
+	}
+	if !s.auth.checkPassword(r.Context(), user, change.OldPassword) {
+		return badRequestCause(errors.New("incorrect 'oldPassword'"))
+	}
+
+	if err := s.users.SetPassword(r.Context(), user.ID, change.NewPassword); err != nil {
+		return internalErrorCause(err)
+	}
+	return statusOK
+}
+
+// Delete the account with all of its todos and categories and end the session.
+//
+// DELETE /api/me
+func (s server) handleDeleteMe(w http.ResponseWriter, r *http.Request) {
+	claims, ok := router.GetClaims(r)
+	if !ok {
+		fail(w, r, http.StatusInternalServerError, "internal error")
+		return
+	}
+	err := s.users.DeleteUser(r.Context(), claims.ID)
+	if err == stores.ErrNotFound {
+		fail(w, r, http.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		router.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	http.SetCookie(w, s.expiredCookie(r, "jwt", "/"))
+}
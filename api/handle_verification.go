@@ -0,0 +1,173 @@
+package api
+
+import (
+	"check42/api/router"
+	"check42/model"
+	"check42/store/stores"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	verifyEmailTTL   = 48 * time.Hour
+	resetPasswordTTL = time.Hour
+)
+
+// Page the link in the verification mail points to. It only confirms the
+// email through a POST, as mail scanners open links and would otherwise
+// use up the token.
+//
+// GET /auth/verify?token={token}
+func handleVerifyPage(w http.ResponseWriter, r *http.Request) {
+	html, err := os.ReadFile("static/verify/index.html")
+	if err != nil {
+		fail(w, r, http.StatusNotFound, "file not found")
+		return
+	}
+	io.WriteString(w, string(html))
+}
+
+// Mark the user's email as verified with the token sent out on signup.
+//
+// POST /auth/verify/confirm?token={token}
+func (s server) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		fail(w, r, http.StatusBadRequest, "missing field 'token'")
+		return
+	}
+	userID, err := s.users.ConsumeToken(r.Context(), model.TokenVerifyEmail, hashToken(token))
+	if err == stores.ErrNotFound {
+		fail(w, r, http.StatusBadRequest, "invalid or expired token")
+		return
+	}
+	if err != nil {
+		fail(w, r, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if err := s.users.SetEmailVerified(r.Context(), userID); err != nil {
+		fail(w, r, http.StatusInternalServerError, "internal error")
+		return
+	}
+}
+
+// Send a new verification mail. Always answers with 202 so the endpoint
+// cannot be used to find out which emails are registered.
+//
+// POST /auth/verify
+func (s server) handleResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req model.EmailRequest
+	if !decodeBody(w, r, &req, "could not read email") {
+		return
+	}
+	user, err := s.users.GetUserByEmail(r.Context(), req.Email)
+	if err == nil && !user.EmailVerified {
+		if err := s.sendVerification(r.Context(), user); err != nil {
+			router.Logger(r).Error("Sending verification mail failed", "err", err)
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Send a password reset link. Always answers with 202 so the endpoint
+// cannot be used to find out which emails are registered.
+//
+// POST /auth/forgot
+func (s server) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req model.EmailRequest
+	if !decodeBody(w, r, &req, "could not read email") {
+		return
+	}
+	user, err := s.users.GetUserByEmail(r.Context(), req.Email)
+	if err == nil {
+		if err := s.sendPasswordReset(r.Context(), user); err != nil {
+			router.Logger(r).Error("Sending password reset mail failed", "err", err)
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Set a new password with the token from the reset mail.
+// Receiving the mail also proves ownership of the address.
+//
+// POST /auth/reset
+func (s server) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req model.PasswordReset
+	if !decodeBody(w, r, &req, "could not read reset") {
+		return
+	}
+	if err := req.Validate(); err.Err() {
+		router.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	userID, err := s.users.ConsumeToken(r.Context(), model.TokenResetPassword, hashToken(req.Token))
+	if err == stores.ErrNotFound {
+		fail(w, r, http.StatusBadRequest, "invalid or expired token")
+		return
+	}
+	if err != nil {
+		fail(w, r, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if err := s.users.SetPassword(r.Context(), userID, req.Password); err != nil {
+		fail(w, r, http.StatusInternalServerError, "internal error")
+		return
+	}
+	// whoever knew the old password must not stay logged in
+	if err := s.users.EndSessions(r.Context(), userID); err != nil {
+		fail(w, r, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if err := s.users.SetEmailVerified(r.Context(), userID); err != nil {
+		fail(w, r, http.StatusInternalServerError, "internal error")
+		return
+	}
+}
+
+func (s server) sendVerification(ctx context.Context, user model.User) error {
+	token, err := s.issueToken(ctx, user.ID, model.TokenVerifyEmail, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	link := s.publicURL + "/auth/verify?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Hi %s,\n\nplease confirm your email address by opening the link below.\n\n%s\n\nThe link is valid for 48 hours.\n", user.Name, link)
+	return s.mailer.Send(user.Email, "Confirm your check42 account", body)
+}
+
+func (s server) sendPasswordReset(ctx context.Context, user model.User) error {
+	token, err := s.issueToken(ctx, user.ID, model.TokenResetPassword, resetPasswordTTL)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Hi %s,\n\nsomeone requested a password reset for your account. "+
+		"If that was you, send the token below together with your new password to %s/auth/reset.\n\n%s\n\n"+
+		"The token is valid for one hour. If you did not request a reset, you can ignore this mail.\n", user.Name, s.publicURL, token)
+	return s.mailer.Send(user.Email, "Reset your check42 password", body)
+}
+
+// Create a random single-use token and save its hash.
+func (s server) issueToken(ctx context.Context, userID int64, purpose string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	if err := s.users.CreateToken(ctx, userID, purpose, hashToken(token), time.Now().Add(ttl)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"check42/api/router"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthBodyLimit(t *testing.T) {
+	s := server{}
+	handlers := map[string]http.HandlerFunc{
+		"/auth/signin": s.handleSignin,
+		"/auth/verify": s.handleResendVerification,
+		"/auth/forgot": s.handleForgotPassword,
+		"/auth/reset":  s.handleResetPassword,
+	}
+	huge := `{"email":"` + strings.Repeat("a", router.MaxBodyBytes) + `"}`
+	for path, handler := range handlers {
+		for body, code := range map[string]int{huge: http.StatusRequestEntityTooLarge, "{": http.StatusBadRequest} {
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+			if rec.Code != code {
+				t.Errorf("%s with %d bytes: got status %d, want %d", path, len(body), rec.Code, code)
+			}
+		}
+	}
+}
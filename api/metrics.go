@@ -0,0 +1,72 @@
+package api
+
+import (
+	"check42/store/stores"
+	"context"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Todo activity counted as it happens, so scrapes don't need to query the
+// store. Counters start at zero with every instance, rates and sums across
+// instances are done in Prometheus.
+type todoMetrics struct {
+	created   prometheus.Counter
+	completed prometheus.Counter
+}
+
+func newTodoMetrics(reg prometheus.Registerer) *todoMetrics {
+	m := &todoMetrics{
+		created: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "check42_todos_created_total",
+			Help: "Number of created todos.",
+		}),
+		completed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "check42_todos_completed_total",
+			Help: "Number of todos marked as done, on creation or later.",
+		}),
+	}
+	reg.MustRegister(m.created, m.completed)
+	return m
+}
+
+// Count a change of a todo's done flag.
+func (m *todoMetrics) done(before, after bool) {
+	if after && !before {
+		m.completed.Inc()
+	}
+}
+
+// User totals read from the store on every scrape, so they stay correct
+// across restarts and multiple instances.
+type userStatsCollector struct {
+	users stores.UserStore
+
+	userCount     *prometheus.Desc
+	disabledCount *prometheus.Desc
+}
+
+func newUserStatsCollector(users stores.UserStore) *userStatsCollector {
+	return &userStatsCollector{
+		users:         users,
+		userCount:     prometheus.NewDesc("check42_users", "Number of registered users.", nil, nil),
+		disabledCount: prometheus.NewDesc("check42_users_disabled", "Number of disabled users.", nil, nil),
+	}
+}
+
+func (c *userStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.userCount
+	ch <- c.disabledCount
+}
+
+// Metrics whose query fails are left out of the scrape.
+func (c *userStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	users, err := c.users.GetUserStats(context.Background())
+	if err != nil {
+		slog.Error("Collecting user metrics failed", "err", err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.userCount, prometheus.GaugeValue, float64(users.Users))
+	ch <- prometheus.MustNewConstMetric(c.disabledCount, prometheus.GaugeValue, float64(users.DisabledUsers))
+}
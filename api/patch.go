@@ -0,0 +1,57 @@
+package api
+
+import (
+	"bytes"
+	"check42/api/jsonpatch"
+	"check42/api/router"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+)
+
+// A JSON Merge Patch or JSON Patch sent as request body.
+type patchBody struct {
+	mediaType string // empty if the body is neither
+	data      []byte
+}
+
+// Read the body if its content type is one of the patch formats.
+func readPatch(r *http.Request) (patchBody, router.HttpStatus) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != jsonpatch.MergePatchType && mediaType != jsonpatch.PatchType {
+		return patchBody{}, statusOK
+	}
+	data, status := router.ReadBody(r)
+	if status.Err != nil {
+		return patchBody{}, status
+	}
+	return patchBody{mediaType: mediaType, data: data}, statusOK
+}
+
+func (p patchBody) present() bool {
+	return p.mediaType != ""
+}
+
+// Apply the patch to the JSON representation of current and decode the
+// result into dst. Members dst does not know are rejected.
+func (p patchBody) apply(current, dst any) router.HttpStatus {
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return internalErrorCause(err)
+	}
+	if p.mediaType == jsonpatch.MergePatchType {
+		doc, err = jsonpatch.MergePatch(doc, p.data)
+	} else {
+		doc, err = jsonpatch.Apply(doc, p.data)
+	}
+	if err != nil {
+		return badRequestCause(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return badRequestCause(fmt.Errorf("invalid result: %w", err))
+	}
+	return statusOK
+}
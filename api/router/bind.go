@@ -0,0 +1,222 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+)
+
+// Processes a request with its input already bound and validated and the
+// claims of the authenticated user. Input fields are populated by their tags:
+//
+//	path:"id"     from the path value {id}
+//	query:"done"  from the URL parameter, left untouched if missing or empty
+//	body:"json"   from the JSON body
+//
+// Supported path and query types are strings, booleans, numbers and pointers
+// to these, which stay nil if the parameter is missing. Afterwards input and
+// body are checked against their validation tags.
+type InputProcessFunc[In, Out any] func(r *http.Request, claims *Claims, in In) (Out, HttpStatus)
+
+// Same as InputProcessFunc but without returning a body in the response.
+type NoValueInputProcessFunc[In any] func(r *http.Request, claims *Claims, in In) HttpStatus
+
+// Bind the request to In before calling the InputProcessFunc. Malformed input
+// is answered with 400, bodies over MaxBodyBytes with 413 and missing claims
+// with 500, so it may only be used on routes behind an authentication
+// middleware.
+func Handle[In, Out any](p InputProcessFunc[In, Out]) Endpoint {
+	checkInput(typeOf[In]())
+	return Endpoint{HandlerFunc: process(withInput(p), true), input: typeOf[In](), output: typeOf[Out]()}
+}
+
+// Same as Handle but without returning a body in the response.
+func HandleEmpty[In any](n NoValueInputProcessFunc[In]) Endpoint {
+	checkInput(typeOf[In]())
+	p := func(r *http.Request, claims *Claims, in In) (struct{}, HttpStatus) {
+		return struct{}{}, n(r, claims, in)
+	}
+	return Endpoint{HandlerFunc: process(withInput(p), false), input: typeOf[In]()}
+}
+
+func withInput[In, Out any](p InputProcessFunc[In, Out]) ProcessFunc[Out] {
+	return func(r *http.Request) (Out, HttpStatus) {
+		var zero Out
+		claims, ok := GetClaims(r)
+		if !ok {
+			return zero, HttpStatus{Code: http.StatusInternalServerError, Err: fmt.Errorf("missing claims")}
+		}
+		var in In
+		if status := bind(r, &in); status.Err != nil {
+			return zero, status
+		}
+		return p(r, claims, in)
+	}
+}
+
+// Panic unless t is a struct whose path and query fields have supported
+// types and whose validation tags are sound. Called when a route is created,
+// so mistakes stop the server on startup rather than failing every request.
+func checkInput(t reflect.Type) {
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("input type %s is not a struct", t))
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		_, path := field.Tag.Lookup("path")
+		_, query := field.Tag.Lookup("query")
+		if (path || query) && !isParamType(field.Type) {
+			panic(fmt.Sprintf("unsupported parameter type %s of field %s.%s", field.Type, t, field.Name))
+		}
+		if _, ok := field.Tag.Lookup("body"); ok {
+			checkRules(field.Type)
+		}
+	}
+	checkRules(t)
+}
+
+// Types setValue can parse parameters into.
+func isParamType(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// Populate dst from path values, URL parameters and body and validate the result.
+func bind(r *http.Request, dst any) HttpStatus {
+	val := reflect.ValueOf(dst).Elem()
+	if val.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("input type %s is not a struct", val.Type()))
+	}
+	t := val.Type()
+	hints := NewValidationErr()
+	query := r.URL.Query()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if name, ok := field.Tag.Lookup("path"); ok {
+			raw := r.PathValue(name)
+			if raw == "" {
+				hints.Hint(name, HintMissingOrZero)
+				continue
+			}
+			if err := setValue(val.Field(i), raw); err != nil {
+				hints.Hint(name, HintIncorrectFormat)
+			}
+		}
+		if name, ok := field.Tag.Lookup("query"); ok {
+			raw := query.Get(name)
+			if raw == "" {
+				continue
+			}
+			if err := setValue(val.Field(i), raw); err != nil {
+				hints.Hint(name, HintIncorrectFormat)
+			}
+		}
+		if _, ok := field.Tag.Lookup("body"); ok {
+			if status := DecodeBody(r, val.Field(i).Addr().Interface()); status.Err != nil {
+				return status
+			}
+			if val.Field(i).Kind() == reflect.Struct {
+				validateStruct(val.Field(i), "", hints)
+			}
+		}
+	}
+	if hints.Err() {
+		return HttpStatus{Code: http.StatusBadRequest, Err: hints}
+	}
+
+	validateStruct(val, "", hints)
+	if hints.Err() {
+		return HttpStatus{Code: http.StatusBadRequest, Err: hints}
+	}
+	return HttpStatus{Code: http.StatusOK}
+}
+
+// Upper limit for request bodies read by the router. Larger bodies are
+// answered with 413.
+const MaxBodyBytes = 1 << 20
+
+func limitBody(r *http.Request) io.Reader {
+	return http.MaxBytesReader(nil, r.Body, MaxBodyBytes)
+}
+
+// Decode the JSON body of the request into v, up to MaxBodyBytes.
+func DecodeBody(r *http.Request, v any) HttpStatus {
+	if err := json.NewDecoder(limitBody(r)).Decode(v); err != nil {
+		return bodyError(err)
+	}
+	return HttpStatus{Code: http.StatusOK}
+}
+
+// Read the whole body of the request, up to MaxBodyBytes.
+func ReadBody(r *http.Request) ([]byte, HttpStatus) {
+	data, err := io.ReadAll(limitBody(r))
+	if err != nil {
+		return nil, bodyError(err)
+	}
+	return data, HttpStatus{Code: http.StatusOK}
+}
+
+func bodyError(err error) HttpStatus {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return HttpStatus{Code: http.StatusRequestEntityTooLarge, Err: fmt.Errorf("body exceeds %d bytes", tooLarge.Limit)}
+	}
+	return HttpStatus{Code: http.StatusBadRequest, Err: fmt.Errorf("could not read body: %w", err)}
+}
+
+// Parse raw into the field, allocating pointers as needed.
+func setValue(v reflect.Value, raw string) error {
+	if v.Kind() == reflect.Pointer {
+		ptr := reflect.New(v.Type().Elem())
+		if err := setValue(ptr.Elem(), raw); err != nil {
+			return err
+		}
+		v.Set(ptr)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		panic(fmt.Sprintf("unsupported parameter type %s", v.Type()))
+	}
+	return nil
+}
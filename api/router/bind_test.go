@@ -0,0 +1,114 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type note struct {
+	Text string `json:"text" validate:"required,max=10"`
+}
+
+type noteInput struct {
+	ID    int64   `path:"id" validate:"min=1"`
+	Done  *bool   `query:"done"`
+	Limit int     `query:"limit"`
+	Ratio float64 `query:"ratio"`
+	Note  note    `body:"json"`
+}
+
+func bindRequest(target, body string) (noteInput, HttpStatus) {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	r.SetPathValue("id", "7")
+	var in noteInput
+	status := bind(r, &in)
+	return in, status
+}
+
+func TestBind(t *testing.T) {
+	in, status := bindRequest("/notes/7?done=true&limit=3&ratio=0.5", `{"text":"hello"}`)
+	if status.Err != nil {
+		t.Fatalf("bind: %v", status.Err)
+	}
+	if in.ID != 7 || in.Done == nil || !*in.Done || in.Limit != 3 || in.Ratio != 0.5 || in.Note.Text != "hello" {
+		t.Errorf("bind: got %+v", in)
+	}
+
+	in, status = bindRequest("/notes/7?limit=", `{"text":"hello"}`)
+	if status.Err != nil || in.Done != nil || in.Limit != 0 {
+		t.Errorf("missing parameters: got %+v, %v", in, status.Err)
+	}
+}
+
+func TestBindRejects(t *testing.T) {
+	tests := []struct {
+		name, target, body string
+		code               int
+	}{
+		{"malformed query", "/notes/7?done=maybe", `{"text":"hello"}`, http.StatusBadRequest},
+		{"overflowing query", "/notes/7?limit=99999999999999999999", `{"text":"hello"}`, http.StatusBadRequest},
+		{"malformed body", "/notes/7", `{"text":`, http.StatusBadRequest},
+		{"invalid body", "/notes/7", `{"text":"far too long for a note"}`, http.StatusBadRequest},
+		{"missing body", "/notes/7", ``, http.StatusBadRequest},
+		{"large body", "/notes/7", `{"text":"` + strings.Repeat("a", MaxBodyBytes) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		if _, status := bindRequest(tt.target, tt.body); status.Code != tt.code || status.Err == nil {
+			t.Errorf("%s: got %d, %v, want %d", tt.name, status.Code, status.Err, tt.code)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/notes/-1", strings.NewReader(`{"text":"hello"}`))
+	r.SetPathValue("id", "-1")
+	var in noteInput
+	if status := bind(r, &in); status.Code != http.StatusBadRequest {
+		t.Errorf("id below minimum: got %d, %v", status.Code, status.Err)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	endpoint := Handle(func(r *http.Request, claims *Claims, in noteInput) (note, HttpStatus) {
+		return note{Text: claims.Name + ": " + in.Note.Text}, HttpStatus{Code: http.StatusOK}
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/notes/7", strings.NewReader(`{"text":"hello"}`))
+	r.SetPathValue("id", "7")
+	rec := httptest.NewRecorder()
+	endpoint.ServeHTTP(rec, withClaims(r, &Claims{ID: 1, Name: "alice"}))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"alice: hello"`) {
+		t.Errorf("got %d %s", rec.Code, rec.Body)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/notes/7", strings.NewReader(`{"text":"hello"}`))
+	r.SetPathValue("id", "7")
+	rec = httptest.NewRecorder()
+	endpoint.ServeHTTP(rec, r)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("without claims: got %d, want 500", rec.Code)
+	}
+}
+
+func TestCheckInput(t *testing.T) {
+	type list struct {
+		IDs []string `query:"ids"`
+	}
+	type rules struct {
+		Body struct {
+			A string `validate:"shiny"`
+		} `body:"json"`
+	}
+	for _, typ := range []reflect.Type{reflect.TypeOf(0), reflect.TypeOf(&noteInput{}), reflect.TypeOf(list{}), reflect.TypeOf(rules{})} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v: no panic", typ)
+				}
+			}()
+			checkInput(typ)
+		}()
+	}
+	checkInput(reflect.TypeOf(noteInput{}))
+}
@@ -0,0 +1,96 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Identify the current state of a resource for conditional requests.
+type Validators struct {
+	ETag         string // quoted entity tag, e.g. `"3"`
+	LastModified time.Time
+}
+
+// Implemented by results of a ProcessFunc to send ETag and Last-Modified
+// headers. GET and HEAD requests whose If-None-Match or If-Modified-Since
+// still match are answered with 304 Not Modified instead of the body.
+type Validated interface {
+	Validators() Validators
+}
+
+func (v Validators) setHeaders(h http.Header) {
+	if v.ETag != "" {
+		h.Set("ETag", v.ETag)
+	}
+	if !v.LastModified.IsZero() {
+		h.Set("Last-Modified", v.LastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// Whether the client's copy, as described by If-None-Match or, without it,
+// If-Modified-Since, is still current.
+func (v Validators) notModified(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		return matchETag(header, v.ETag, true)
+	}
+	if header := r.Header.Get("If-Modified-Since"); header != "" && !v.LastModified.IsZero() {
+		since, err := http.ParseTime(header)
+		return err == nil && !v.LastModified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// Check If-Match or, without it, If-Unmodified-Since against the current
+// state of a resource before changing it. Fails with 412 Precondition Failed
+// if the client's copy is outdated.
+func CheckPreconditions(r *http.Request, v Validators) HttpStatus {
+	failed := HttpStatus{Code: http.StatusPreconditionFailed, Err: errors.New("resource has been modified")}
+	if header := r.Header.Get("If-Match"); header != "" {
+		if !matchETag(header, v.ETag, false) {
+			return failed
+		}
+		return HttpStatus{Code: http.StatusOK}
+	}
+	if header := r.Header.Get("If-Unmodified-Since"); header != "" && !v.LastModified.IsZero() {
+		since, err := http.ParseTime(header)
+		if err == nil && v.LastModified.Truncate(time.Second).After(since) {
+			return failed
+		}
+	}
+	return HttpStatus{Code: http.StatusOK}
+}
+
+// Whether the request makes a change conditional through If-Match or
+// If-Unmodified-Since.
+func HasPreconditions(r *http.Request) bool {
+	return r.Header.Get("If-Match") != "" || r.Header.Get("If-Unmodified-Since") != ""
+}
+
+// Whether the comma separated list of entity tags in header contains etag
+// or is '*'. Weak tags only match with weak comparison.
+func matchETag(header, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+		if candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var modified = time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+
+type versioned struct {
+	Text string `json:"text"`
+}
+
+func (versioned) Validators() Validators {
+	return Validators{ETag: `"3"`, LastModified: modified}
+}
+
+func TestMatchETag(t *testing.T) {
+	tests := []struct {
+		header, etag string
+		weak, want   bool
+	}{
+		{`"3"`, `"3"`, false, true},
+		{`"1", "3"`, `"3"`, false, true},
+		{`"4"`, `"3"`, false, false},
+		{`*`, `"3"`, false, true},
+		{`*`, ``, false, false},
+		{`W/"3"`, `"3"`, false, false},
+		{`W/"3"`, `"3"`, true, true},
+		{`"3"`, `W/"3"`, true, true},
+	}
+	for _, tt := range tests {
+		if got := matchETag(tt.header, tt.etag, tt.weak); got != tt.want {
+			t.Errorf("matchETag(%s, %s, %t): got %t", tt.header, tt.etag, tt.weak, got)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	endpoint := Proc(func(r *http.Request) (versioned, HttpStatus) {
+		return versioned{Text: "hello"}, HttpStatus{Code: http.StatusOK}
+	})
+	tests := []struct {
+		method, header, value string
+		code                  int
+	}{
+		{http.MethodGet, "", "", http.StatusOK},
+		{http.MethodGet, "If-None-Match", `"3"`, http.StatusNotModified},
+		{http.MethodGet, "If-None-Match", `W/"3"`, http.StatusNotModified},
+		{http.MethodGet, "If-None-Match", `"2"`, http.StatusOK},
+		{http.MethodHead, "If-None-Match", `"3"`, http.StatusNotModified},
+		{http.MethodPost, "If-None-Match", `"3"`, http.StatusOK},
+		{http.MethodGet, "If-Modified-Since", modified.Format(http.TimeFormat), http.StatusNotModified},
+		{http.MethodGet, "If-Modified-Since", modified.Add(-time.Second).Format(http.TimeFormat), http.StatusOK},
+		{http.MethodGet, "If-Modified-Since", "yesterday", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/", nil)
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		rec := httptest.NewRecorder()
+		endpoint.ServeHTTP(rec, r)
+		if rec.Code != tt.code {
+			t.Errorf("%s with %s %s: got %d, want %d", tt.method, tt.header, tt.value, rec.Code, tt.code)
+		}
+		if rec.Header().Get("ETag") != `"3"` || rec.Header().Get("Last-Modified") != "Wed, 01 May 2024 12:00:00 GMT" {
+			t.Errorf("%s with %s %s: got headers %v", tt.method, tt.header, tt.value, rec.Header())
+		}
+	}
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	v := versioned{}.Validators()
+	tests := []struct {
+		header, value string
+		code          int
+	}{
+		{"", "", http.StatusOK},
+		{"If-Match", `"3"`, http.StatusOK},
+		{"If-Match", `"2", "3"`, http.StatusOK},
+		{"If-Match", `"2"`, http.StatusPreconditionFailed},
+		{"If-Match", `W/"3"`, http.StatusPreconditionFailed},
+		{"If-Unmodified-Since", modified.Format(http.TimeFormat), http.StatusOK},
+		{"If-Unmodified-Since", modified.Add(-time.Second).Format(http.TimeFormat), http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPut, "/", nil)
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		if status := CheckPreconditions(r, v); status.Code != tt.code {
+			t.Errorf("%s %s: got %d, want %d", tt.header, tt.value, status.Code, tt.code)
+		}
+		if got := HasPreconditions(r); got != (tt.header != "") {
+			t.Errorf("%s %s: HasPreconditions got %t", tt.header, tt.value, got)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Which cross-origin requests browsers may make and what they get to see.
+//
+// Allowed origins are given as 'scheme://host[:port]' and may contain
+// wildcards, e.g. 'https://*.example.com' or 'chrome-extension://*'.
+// A single '*' allows every origin but can't be combined with credentials.
+type CORSPolicy struct {
+	AllowedOrigins   []string
+	AllowedHeaders   []string // request headers besides the CORS safelisted ones
+	ExposedHeaders   []string // response headers besides the CORS safelisted ones
+	AllowCredentials bool     // send cookies and expose responses to credentialed requests
+	MaxAge           time.Duration
+}
+
+// Headers clients of the API usually send.
+var DefaultCORSHeaders = []string{"Authorization", "Content-Type", "X-Request-ID", "If-Match", "If-None-Match"}
+
+// Add CORS headers for allowed origins and answer preflight requests with
+// the methods of the matched route. Requests from other origins pass without
+// CORS headers, so browsers hide the response, while their preflights are
+// rejected with 403.
+//
+// Needs to be registered after the authentication middleware so it runs
+// before it, as preflight requests never carry credentials.
+func CORS(policy CORSPolicy) Middleware {
+	if policy.AllowCredentials && slices.Contains(policy.AllowedOrigins, "*") {
+		panic("CORS policy can't allow credentials for every origin")
+	}
+	if policy.AllowedHeaders == nil {
+		policy.AllowedHeaders = DefaultCORSHeaders
+	}
+	allowedHeaders := strings.Join(policy.AllowedHeaders, ", ")
+	exposedHeaders := strings.Join(policy.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(policy.MaxAge.Seconds()))
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next(w, r)
+				return
+			}
+			preflight := isPreflight(r)
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			if preflight {
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+			}
+
+			if !policy.allows(origin) {
+				if preflight {
+					WriteError(w, r, http.StatusForbidden, errors.New("origin not allowed"))
+					return
+				}
+				next(w, r)
+				return
+			}
+
+			if slices.Contains(policy.AllowedOrigins, "*") {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if policy.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if !preflight {
+				if exposedHeaders != "" {
+					h.Set("Access-Control-Expose-Headers", exposedHeaders)
+				}
+				next(w, r)
+				return
+			}
+
+			allowed := AllowedMethods(r)
+			if !slices.Contains(allowed, r.Header.Get("Access-Control-Request-Method")) {
+				h.Set("Allow", strings.Join(allowed, ", "))
+				WriteError(w, r, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+				return
+			}
+			h.Set("Access-Control-Allow-Methods", strings.Join(allowed, ", "))
+			h.Set("Access-Control-Allow-Headers", allowedHeaders)
+			if policy.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+func (policy CORSPolicy) allows(origin string) bool {
+	return matchOrigin(policy.AllowedOrigins, origin)
+}
+
+// Origins whose credentialed requests the policy lets browsers make, nil if
+// it doesn't allow credentials. CSRFProtect needs to trust them as well.
+func (policy CORSPolicy) CredentialedOrigins() []string {
+	if !policy.AllowCredentials {
+		return nil
+	}
+	return policy.AllowedOrigins
+}
+
+// Whether the origin matches one of the patterns, see CORSPolicy.
+func matchOrigin(patterns []string, origin string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" || strings.EqualFold(pattern, origin) {
+			return true
+		}
+		if ok, _ := path.Match(pattern, origin); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func corsServer(policy CORSPolicy) http.Handler {
+	base := New("/")
+	base.Use(CORS(policy))
+	todo := base.Subroute("todo")
+	todo.OnGet(ok("todos"))
+	todo.OnPost(ok("create"))
+	return NewServer("", base).Handler
+}
+
+func TestCORS(t *testing.T) {
+	handler := corsServer(CORSPolicy{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		ExposedHeaders:   []string{"ETag"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	})
+	request := func(method, origin, requestMethod string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/todo", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if requestMethod != "" {
+			r.Header.Set("Access-Control-Request-Method", requestMethod)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	rec := request(http.MethodGet, "https://app.example.com", "")
+	h := rec.Header()
+	if rec.Code != http.StatusOK || h.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		h.Get("Access-Control-Allow-Credentials") != "true" || h.Get("Access-Control-Expose-Headers") != "ETag" {
+		t.Errorf("allowed origin: got %d, headers %v", rec.Code, h)
+	}
+	if !slices.Contains(h.Values("Vary"), "Origin") {
+		t.Errorf("allowed origin: missing Vary, got %v", h)
+	}
+
+	rec = request(http.MethodGet, "https://eu.example.org", "")
+	if rec.Header().Get("Access-Control-Allow-Origin") != "https://eu.example.org" {
+		t.Errorf("wildcard origin: got headers %v", rec.Header())
+	}
+
+	rec = request(http.MethodGet, "https://evil.com", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("foreign origin: got %d, headers %v", rec.Code, rec.Header())
+	}
+
+	rec = request(http.MethodOptions, "https://app.example.com", http.MethodPost)
+	h = rec.Header()
+	if rec.Code != http.StatusNoContent || h.Get("Access-Control-Allow-Methods") != "GET, HEAD, OPTIONS, POST" ||
+		h.Get("Access-Control-Allow-Headers") == "" || h.Get("Access-Control-Max-Age") != "3600" {
+		t.Errorf("preflight: got %d, headers %v", rec.Code, h)
+	}
+	if rec := request(http.MethodOptions, "https://app.example.com", http.MethodDelete); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("preflight for unsupported method: got %d", rec.Code)
+	}
+	if rec := request(http.MethodOptions, "https://evil.com", http.MethodPost); rec.Code != http.StatusForbidden {
+		t.Errorf("preflight from foreign origin: got %d", rec.Code)
+	}
+	if rec := request(http.MethodOptions, "", ""); rec.Code != http.StatusNoContent || rec.Header().Get("Allow") == "" {
+		t.Errorf("plain OPTIONS: got %d, headers %v", rec.Code, rec.Header())
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	handler := corsServer(CORSPolicy{AllowedOrigins: []string{"*"}})
+	r := httptest.NewRequest(http.MethodGet, "/todo", nil)
+	r.Header.Set("Origin", "https://anyone.com")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" || rec.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("got headers %v", rec.Header())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("credentials for every origin accepted")
+		}
+	}()
+	CORS(CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+}
+
+func TestCredentialedOrigins(t *testing.T) {
+	origins := []string{"https://*.example.org"}
+	if got := (CORSPolicy{AllowedOrigins: origins}).CredentialedOrigins(); got != nil {
+		t.Errorf("without credentials: got %v", got)
+	}
+	policy := CORSPolicy{AllowedOrigins: origins, AllowCredentials: true}
+	trusted := policy.CredentialedOrigins()
+
+	// every origin CORS lets send cookies has to pass the CSRF check
+	handler := CSRFProtect(trusted...)(func(w http.ResponseWriter, r *http.Request) {})
+	r := httptest.NewRequest(http.MethodPost, "http://api.example.com/todo", nil)
+	r.AddCookie(&http.Cookie{Name: "token", Value: "jwt"})
+	r.Header.Set("Sec-Fetch-Site", "cross-site")
+	r.Header.Set("Origin", "https://eu.example.org")
+	rec := httptest.NewRecorder()
+	handler(rec, r)
+	if !policy.allows("https://eu.example.org") || rec.Code != http.StatusOK {
+		t.Errorf("credentialed CORS origin rejected by CSRF check: got %d", rec.Code)
+	}
+}
@@ -0,0 +1,48 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRFProtect(t *testing.T) {
+	handler := CSRFProtect("https://app.example.com", "https://*.partner.com")(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name    string
+		method  string
+		cookie  bool
+		headers map[string]string
+		code    int
+	}{
+		{"safe method", http.MethodGet, true, map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.com"}, http.StatusOK},
+		{"without cookies", http.MethodPost, false, map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.com"}, http.StatusOK},
+		{"same origin", http.MethodPost, true, map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusOK},
+		{"user initiated", http.MethodPost, true, map[string]string{"Sec-Fetch-Site": "none"}, http.StatusOK},
+		{"cross site", http.MethodPost, true, map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.com"}, http.StatusForbidden},
+		{"cross site without origin", http.MethodPost, true, map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"trusted origin", http.MethodPost, true, map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://app.example.com"}, http.StatusOK},
+		{"trusted wildcard", http.MethodPost, true, map[string]string{"Sec-Fetch-Site": "same-site", "Origin": "https://eu.partner.com"}, http.StatusOK},
+		{"own host", http.MethodDelete, true, map[string]string{"Origin": "http://example.com"}, http.StatusOK},
+		{"foreign origin", http.MethodDelete, true, map[string]string{"Origin": "https://evil.com"}, http.StatusForbidden},
+		{"foreign referer", http.MethodPut, true, map[string]string{"Referer": "https://evil.com/page"}, http.StatusForbidden},
+		{"null origin with referer", http.MethodPut, true, map[string]string{"Origin": "null", "Referer": "https://app.example.com/"}, http.StatusOK},
+		{"no browser", http.MethodPost, true, nil, http.StatusOK},
+		{"bearer token", http.MethodPost, true, map[string]string{"Origin": "https://evil.com", "Authorization": "Bearer abc"}, http.StatusOK},
+		{"basic auth", http.MethodPost, true, map[string]string{"Origin": "https://evil.com", "Authorization": "Basic YTpi"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "http://example.com/api/todo", nil)
+		if tt.cookie {
+			r.AddCookie(&http.Cookie{Name: "token", Value: "jwt"})
+		}
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		if rec.Code != tt.code {
+			t.Errorf("%s: got %d, want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+}
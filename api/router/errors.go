@@ -0,0 +1,53 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Body of every error response.
+// Code is a machine readable version of the status, Details lists the
+// validation hints per field if the error was caused by a ValidationErr.
+type ErrorResponse struct {
+	Code      string              `json:"code"`
+	Message   string              `json:"message"`
+	Details   map[string][]string `json:"details,omitempty"`
+	RequestID string              `json:"requestId,omitempty"`
+}
+
+// Write the error as JSON envelope with the given status.
+// Messages of server errors are not exposed to the client but logged instead.
+func WriteError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	body := ErrorResponse{
+		Code:      errorCode(status),
+		Message:   http.StatusText(status),
+		RequestID: GetRequestID(r),
+	}
+	if status >= 500 {
+		Logger(r).Error("Server error", "status", status, "path", r.URL.Path, "err", err)
+	} else if err != nil {
+		body.Message = err.Error()
+	}
+	if ve, ok := err.(validationErr); ok {
+		body.Code = "validation_failed"
+		body.Message = "validation failed"
+		body.Details = ve.hints
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Turn the status text into snake case, e.g. 404 becomes 'not_found'.
+func errorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	text = strings.ReplaceAll(strings.ToLower(text), "-", " ")
+	text = strings.ReplaceAll(text, "'", "")
+	return strings.Join(strings.Fields(text), "_")
+}
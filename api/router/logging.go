@@ -0,0 +1,94 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+var keyRequestInfo = ctxKey{"requestInfo"}
+
+// Details that only become known further down the chain,
+// filled in by the authentication and tracing middlewares.
+type requestInfo struct {
+	userID      int64
+	authFailure string // scheme of a rejected authentication
+	traceID     string
+}
+
+// Get the requestInfo of an outer middleware or attach a new one.
+func withRequestInfo(r *http.Request) (*requestInfo, *http.Request) {
+	if info, ok := r.Context().Value(keyRequestInfo).(*requestInfo); ok {
+		return info, r
+	}
+	info := &requestInfo{}
+	return info, r.WithContext(context.WithValue(r.Context(), keyRequestInfo, info))
+}
+
+// Write a structured access log entry after every request with status,
+// latency, bytes written, the authenticated user and the request id.
+// Server errors are logged at error level.
+//
+// Needs to be registered before RequestID so the id is already assigned.
+func LogCall(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info, r := withRequestInfo(r)
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next(rec, r)
+
+		level := slog.LevelInfo
+		if rec.code >= 500 {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", RoutePattern(r)),
+			slog.Int("status", rec.code),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int("bytes", rec.bytes),
+			slog.String("request_id", GetRequestID(r)),
+		}
+		if info.userID != 0 {
+			attrs = append(attrs, slog.Int64("user_id", info.userID))
+		}
+		if info.traceID != "" {
+			attrs = append(attrs, slog.String("trace_id", info.traceID))
+		}
+		slog.LogAttrs(r.Context(), level, "Request", attrs...)
+	}
+}
+
+// Turn panics in handlers into a 500 response instead of dropping the
+// connection. The panic is logged together with its stack trace.
+//
+// Needs to be registered before LogCall so the response is logged.
+func Recover(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				// deliberate abort, the server handles it silently
+				panic(err)
+			}
+			Logger(r).Error("Panic", "err", err, "stack", string(debug.Stack()))
+			if !rec.wroteHeader {
+				WriteError(rec, r, http.StatusInternalServerError, errors.New("panic"))
+			}
+		}()
+		next(rec, r)
+	}
+}
+
+// Logger carrying the request id, for log messages concerning the request.
+func Logger(r *http.Request) *slog.Logger {
+	return slog.Default().With("request_id", GetRequestID(r))
+}
@@ -0,0 +1,77 @@
+package router
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus metrics about the requests passing the Instrument middleware.
+// Requests are labelled by route template instead of the raw path to keep
+// the number of series bounded.
+type Metrics struct {
+	requests     *prometheus.CounterVec
+	duration     *prometheus.HistogramVec
+	inFlight     prometheus.Gauge
+	authFailures *prometheus.CounterVec
+}
+
+// Create the request metrics and register them with reg.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Number of handled requests by route, method and status.",
+		}, []string{"route", "method", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Latency of handled requests by route and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "Number of requests currently being handled.",
+		}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_failures_total",
+			Help: "Number of requests with rejected credentials by scheme.",
+		}, []string{"scheme"}),
+	}
+	reg.MustRegister(m.requests, m.duration, m.inFlight, m.authFailures)
+	return m
+}
+
+// Middleware recording count, latency and status of every request as well
+// as credentials rejected by BasicAuth and JWTAuth.
+//
+// Needs to be registered after the authentication middlewares so it runs
+// before them, and before Recover so panics count as 500.
+func (m *Metrics) Instrument(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		info, r := withRequestInfo(r)
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next(rec, r)
+
+		route := RoutePattern(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		method := r.Method
+		if !slices.Contains(openAPIMethods, method) {
+			// clients can send any verb, don't let them create new series
+			method = "OTHER"
+		}
+		m.requests.WithLabelValues(route, method, strconv.Itoa(rec.code)).Inc()
+		m.duration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+		if info.authFailure != "" {
+			m.authFailures.WithLabelValues(info.authFailure).Inc()
+		}
+	}
+}
@@ -3,8 +3,8 @@ package router
 import (
 	"context"
 	"errors"
-	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -21,16 +21,7 @@ type Middleware func(http.HandlerFunc) http.HandlerFunc
 // If the user has no claims or the provided authentication scheme is not supported
 // by the implentation return false and nil Claims.
 type Authority interface {
-	Authorize(scheme string, payload string) (bool, *Claims)
-}
-
-// Log a request's path and method on every call.
-// Does not log on exit.
-func LogCall(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println(r.Method, r.URL.Path)
-		next(w, r)
-	}
+	Authorize(ctx context.Context, scheme string, payload string) (bool, *Claims)
 }
 
 // Extract the basic authentication header and pass it to the authority on request.
@@ -39,7 +30,7 @@ func BasicAuth(authority Authority) Middleware {
 		return func(w http.ResponseWriter, r *http.Request) {
 			header, ok := r.Header["Authorization"]
 			if !ok {
-				w.WriteHeader(http.StatusUnauthorized)
+				WriteError(w, r, http.StatusUnauthorized, errors.New("missing authorization"))
 				return
 			}
 
@@ -47,39 +38,102 @@ func BasicAuth(authority Authority) Middleware {
 				// sample header: 'Basic YWRtaW46YWRtaW4='
 				split := strings.SplitN(val, " ", 2)
 				if len(split) != 2 {
-					w.WriteHeader(http.StatusBadRequest)
+					WriteError(w, r, http.StatusBadRequest, errors.New("malformed authorization header"))
 					return
 				}
 				if strings.ToLower(split[0]) != "basic" {
 					continue
 				}
-				if success, claims := authority.Authorize("basic", split[1]); success {
-					ctx := context.WithValue(r.Context(), keyClaims, claims)
-					next(w, r.WithContext(ctx))
+				if success, claims := authority.Authorize(r.Context(), "basic", split[1]); success {
+					next(w, withClaims(r, claims))
 					return
 				}
 			}
-			w.WriteHeader(http.StatusUnauthorized)
+			authFailed(r, "basic")
+			WriteError(w, r, http.StatusUnauthorized, errors.New("invalid credentials"))
 		}
 	}
 }
 
-// Extract the 'jwt' cookie and pass it to the authority on request.
+// Extract the JWT from a bearer authorization header or the 'jwt' cookie
+// and pass it to the authority on request.
+// Requests already authenticated by ClientCertAuth pass unchanged.
 func JWTAuth(authority Authority) Middleware {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
-			c, err := r.Cookie("jwt")
-			if err == http.ErrNoCookie {
-				w.WriteHeader(http.StatusUnauthorized)
+			if _, ok := GetClaims(r); ok {
+				next(w, r)
 				return
 			}
-			jwt := c.Value
-			if success, claims := authority.Authorize("bearer", jwt); success {
-				ctx := context.WithValue(r.Context(), keyClaims, claims)
-				next(w, r.WithContext(ctx))
+			jwt, ok := bearerToken(r)
+			if !ok {
+				c, err := r.Cookie("jwt")
+				if err == http.ErrNoCookie {
+					WriteError(w, r, http.StatusUnauthorized, errors.New("not logged in"))
+					return
+				}
+				jwt = c.Value
+			}
+			if success, claims := authority.Authorize(r.Context(), "bearer", jwt); success {
+				next(w, withClaims(r, claims))
 				return
 			}
-			w.WriteHeader(http.StatusUnauthorized)
+			authFailed(r, "bearer")
+			WriteError(w, r, http.StatusUnauthorized, errors.New("invalid or expired token"))
+		}
+	}
+}
+
+// Pass the common name of a verified TLS client certificate to the authority
+// under the 'mtls' scheme. Requests without one pass unauthenticated, so
+// another authentication middleware can take over.
+//
+// Needs to be registered after the other authentication middleware so it
+// runs before it.
+func ClientCertAuth(authority Authority) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				next(w, r)
+				return
+			}
+			name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+			if success, claims := authority.Authorize(r.Context(), "mtls", name); success {
+				next(w, withClaims(r, claims))
+				return
+			}
+			authFailed(r, "mtls")
+			WriteError(w, r, http.StatusUnauthorized, errors.New("unknown client certificate"))
+		}
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	// sample header: 'Bearer eyJhbGciOiJIUzI1NiIs...'
+	split := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(split) != 2 || strings.ToLower(split[0]) != "bearer" {
+		return "", false
+	}
+	return split[1], true
+}
+
+// Only let requests through whose claims carry one of the given roles.
+// Unauthenticated requests are answered with 401, missing roles with 403.
+//
+// Needs to be registered before the authentication middleware so it runs after it.
+func RequireRole(roles ...string) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := GetClaims(r)
+			if !ok {
+				WriteError(w, r, http.StatusUnauthorized, errors.New("not logged in"))
+				return
+			}
+			if !slices.Contains(roles, claims.Role) {
+				WriteError(w, r, http.StatusForbidden, errors.New("insufficient role"))
+				return
+			}
+			next(w, r)
 		}
 	}
 }
@@ -93,8 +147,26 @@ type ctxKey struct {
 var keyClaims = ctxKey{"claims"}
 
 type Claims struct {
-	ID   int64
-	Name string
+	ID      int64
+	Name    string
+	Role    string
+	Session int64 // version of the user's sessions the token belongs to
+}
+
+// Attach the claims to the request and report the user to the access log.
+func withClaims(r *http.Request, claims *Claims) *http.Request {
+	if info, ok := r.Context().Value(keyRequestInfo).(*requestInfo); ok {
+		info.userID = claims.ID
+	}
+	ctx := context.WithValue(r.Context(), keyClaims, claims)
+	return r.WithContext(ctx)
+}
+
+// Report rejected credentials to the metrics.
+func authFailed(r *http.Request, scheme string) {
+	if info, ok := r.Context().Value(keyRequestInfo).(*requestInfo); ok {
+		info.authFailure = scheme
+	}
 }
 
 // Get the claims from a request. Failing to do so in a context where the operation
@@ -133,6 +205,14 @@ func ValidateJWT(payload string, secret []byte) (*Claims, error) {
 		return nil, errors.New("invalid field 'sub'")
 	}
 	claims.Name = sub
+	// tokens issued before roles were introduced don't carry one
+	if role, ok := raw["role"].(string); ok {
+		claims.Role = role
+	}
+	// neither do tokens issued before sessions could be ended, they are void
+	if session, ok := raw["ses"].(float64); ok {
+		claims.Session = int64(session)
+	}
 
 	return &claims, nil
 }
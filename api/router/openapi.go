@@ -0,0 +1,401 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// General information about the API shown at the top of the OpenAPI document.
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenAPI 3 document. Only the parts the router can derive are modelled.
+type OpenAPIDocument struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       OpenAPIInfo                     `json:"info"`
+	Paths      map[string]map[string]Operation `json:"paths"`
+	Components Components                      `json:"components"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+}
+
+type SecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme,omitempty"`
+	In     string `json:"in,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+}
+
+// Serve the OpenAPI document of the given routes and all their subroutes.
+// The document is built on the first request, once ListenAndServe has
+// registered the routes and propagated their middlewares.
+func OpenAPI(info OpenAPIInfo, routes ...*route) http.HandlerFunc {
+	var once sync.Once
+	var doc []byte
+	return func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			doc, _ = json.Marshal(BuildOpenAPI(info, routes...))
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	}
+}
+
+// Describe the registered routes as OpenAPI document. Request and response
+// bodies are taken from handlers created by Proc, Handle and their variants,
+// authentication from the BasicAuth and JWTAuth middlewares.
+func BuildOpenAPI(info OpenAPIInfo, routes ...*route) OpenAPIDocument {
+	b := schemaBuilder{schemas: make(map[string]*Schema)}
+	doc := OpenAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    info,
+		Paths:   make(map[string]map[string]Operation),
+		Components: Components{
+			Schemas: b.schemas,
+			SecuritySchemes: map[string]SecurityScheme{
+				"basicAuth":  {Type: "http", Scheme: "basic"},
+				"bearerAuth": {Type: "http", Scheme: "bearer"},
+				"cookieAuth": {Type: "apiKey", In: "cookie", Name: "jwt"},
+			},
+		},
+	}
+	errorRef := b.schema(typeOf[ErrorResponse]())
+
+	var walk func(route *route)
+	walk = func(route *route) {
+		if len(route.handlers) != 0 && route.registered {
+			item := make(map[string]Operation)
+			for method, handler := range route.handlers {
+				// custom verbs registered with OnMethod can't be described
+				if slices.Contains(openAPIMethods, method) {
+					item[strings.ToLower(method)] = b.operation(route, method, handler, errorRef)
+				}
+			}
+			doc.Paths[route.fullPath] = item
+		}
+		for _, sr := range route.subroutes {
+			walk(sr)
+		}
+	}
+	for _, r := range routes {
+		walk(r)
+	}
+	return doc
+}
+
+var openAPIMethods = []string{
+	http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete,
+	http.MethodOptions, http.MethodHead, http.MethodPatch, http.MethodTrace,
+}
+
+func (b schemaBuilder) operation(route *route, method string, handler http.Handler, errorRef *Schema) Operation {
+	op := Operation{
+		OperationID: operationID(method, route.fullPath),
+		Responses:   make(map[string]Response),
+	}
+	if tag, _, _ := strings.Cut(strings.TrimPrefix(route.fullPath, "/"), "/"); tag != "" {
+		op.Tags = []string{tag}
+	}
+	endpoint, _ := handler.(Endpoint)
+
+	// parameters declared in the input struct, the remaining path values as strings
+	declared := make(map[string]bool)
+	body := endpoint.body
+	if endpoint.input != nil && endpoint.input.Kind() == reflect.Struct {
+		for i := 0; i < endpoint.input.NumField(); i++ {
+			field := endpoint.input.Field(i)
+			if name, ok := field.Tag.Lookup("path"); ok {
+				op.Parameters = append(op.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: b.schema(field.Type)})
+				declared[name] = true
+			}
+			if name, ok := field.Tag.Lookup("query"); ok {
+				op.Parameters = append(op.Parameters, Parameter{Name: name, In: "query", Schema: b.schema(field.Type)})
+			}
+			if _, ok := field.Tag.Lookup("body"); ok {
+				body = field.Type
+			}
+		}
+	}
+	for _, name := range pathValues(route.fullPath) {
+		if !declared[name] {
+			op.Parameters = append(op.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		}
+	}
+
+	if body != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]MediaType{"application/json": {Schema: b.schema(body)}},
+		}
+		op.Responses["400"] = Response{Description: "Malformed or invalid input", Content: jsonContent(errorRef)}
+	}
+	if endpoint.output != nil {
+		op.Responses["2XX"] = Response{Description: "Success", Content: jsonContent(b.schema(endpoint.output))}
+	} else {
+		op.Responses["2XX"] = Response{Description: "Success"}
+	}
+	if endpoint.output != nil && endpoint.output.Implements(typeOf[Validated]()) {
+		op.Responses["304"] = Response{Description: "Not modified"}
+	}
+	op.Responses["default"] = Response{Description: "Error", Content: jsonContent(errorRef)}
+
+	for _, m := range route.middlewares {
+		switch middlewareID(m) {
+		case middlewareID(BasicAuth(nil)):
+			op.Security = append(op.Security, map[string][]string{"basicAuth": {}})
+		case middlewareID(JWTAuth(nil)):
+			op.Security = append(op.Security, map[string][]string{"bearerAuth": {}}, map[string][]string{"cookieAuth": {}})
+		case middlewareID(RequireRole()):
+			op.Responses["403"] = Response{Description: "Insufficient role", Content: jsonContent(errorRef)}
+		case middlewareID(RateLimit(nil, "", RateLimitPolicy{})):
+			op.Responses["429"] = Response{Description: "Rate limit exceeded", Content: jsonContent(errorRef)}
+		}
+	}
+	if len(op.Security) != 0 {
+		op.Responses["401"] = Response{Description: "Not authenticated", Content: jsonContent(errorRef)}
+	}
+	return op
+}
+
+// Middlewares created by the same constructor share their code, which is
+// enough to recognize them without keeping a registry.
+func middlewareID(m Middleware) uintptr {
+	return reflect.ValueOf(m).Pointer()
+}
+
+func jsonContent(s *Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: s}}
+}
+
+// Names of the wildcards in a ServeMux pattern, e.g. 'id' for '/api/todo/{id}'.
+func pathValues(path string) []string {
+	names := make([]string, 0)
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			name := strings.TrimSuffix(strings.Trim(segment, "{}"), "...")
+			if name != "$" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// Turn method and path into a camel case identifier, e.g. 'getApiTodoId'.
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	upper := true
+	for _, c := range path {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			upper = true
+			continue
+		}
+		if upper {
+			c = unicode.ToUpper(c)
+			upper = false
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Turns Go types into schemas. Named structs are added to the components
+// and referenced, everything else is inlined.
+type schemaBuilder struct {
+	schemas map[string]*Schema
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (b schemaBuilder) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		name := t.Name()
+		if _, ok := b.schemas[name]; !ok {
+			// placeholder to end recursion on self referencing types
+			b.schemas[name] = &Schema{}
+			*b.schemas[name] = *b.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return b.object(t)
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: b.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: b.schema(t.Elem())}
+	}
+	return &Schema{}
+}
+
+func (b schemaBuilder) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, hasTag := field.Tag.Lookup("json")
+		if tag == "-" {
+			continue
+		}
+		// embedded structs are flattened by encoding/json
+		if field.Anonymous && !hasTag {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				inner := b.object(embedded)
+				for name, p := range inner.Properties {
+					s.Properties[name] = p
+				}
+				s.Required = append(s.Required, inner.Required...)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		name := fieldName(field)
+		prop := b.schema(field.Type)
+		rules := strings.Split(field.Tag.Get("validate"), ",")
+		if prop.Ref == "" {
+			applyRules(prop, rules)
+		}
+		if field.Type.Kind() == reflect.Pointer && prop.Ref == "" {
+			prop.Nullable = true
+		}
+		if hasRule(rules, "required") && !hasRule(rules, "optional") {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = prop
+	}
+	slices.Sort(s.Required)
+	return s
+}
+
+// Translate validation rules into schema constraints where possible.
+func applyRules(s *Schema, rules []string) {
+	for _, rule := range rules {
+		rule, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch rule {
+		case "min", "max":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				continue
+			}
+			setBound(s, n, rule == "min")
+		case "range":
+			lo, hi, _ := strings.Cut(arg, ":")
+			if n, err := strconv.ParseFloat(lo, 64); err == nil {
+				setBound(s, n, true)
+			}
+			if n, err := strconv.ParseFloat(hi, 64); err == nil {
+				setBound(s, n, false)
+			}
+		case "email":
+			s.Format = "email"
+		case "enum":
+			s.Enum = strings.Split(arg, "|")
+		}
+	}
+}
+
+func setBound(s *Schema, n float64, lower bool) {
+	switch s.Type {
+	case "string":
+		length := int(n)
+		if lower {
+			s.MinLength = &length
+		} else {
+			s.MaxLength = &length
+		}
+	case "integer", "number":
+		if lower {
+			s.Minimum = &n
+		} else {
+			s.Maximum = &n
+		}
+	}
+}
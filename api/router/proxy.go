@@ -0,0 +1,78 @@
+package router
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var keyClientIP = ctxKey{"clientIP"}
+
+// Take the client address from the X-Forwarded-For header, but only for
+// requests coming from one of the given proxies. Entries are read from the
+// right, skipping the proxies, so the first unknown address is the client.
+// Anything left of it could have been made up by the client itself.
+//
+// It has to run before the middlewares calling ClientIP.
+func TrustProxies(proxies []netip.Prefix) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if ip := forwardedFor(r, proxies); ip != "" {
+				r = r.WithContext(context.WithValue(r.Context(), keyClientIP, ip))
+			}
+			next(w, r)
+		}
+	}
+}
+
+// Address of the client without port. Unless TrustProxies resolved it,
+// forwarding headers are ignored as they can be set by anyone.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(keyClientIP).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// The rightmost forwarded address that is not a trusted proxy, or an empty
+// string if the request did not come through one.
+func forwardedFor(r *http.Request, proxies []netip.Prefix) string {
+	remote, err := netip.ParseAddr(remoteIP(r))
+	if err != nil || !trusted(remote, proxies) {
+		return ""
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// not written by a proxy, keep the last address one of them vouched for
+			break
+		}
+		client = addr
+		if !trusted(addr, proxies) {
+			break
+		}
+	}
+	return client.Unmap().String()
+}
+
+func trusted(addr netip.Addr, proxies []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, p := range proxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.1/32")}
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"direct", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"untrusted remote", "203.0.113.7:1234", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"through proxy", "10.0.0.2:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed entries", "10.0.0.2:1234", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"proxy chain", "10.0.0.2:1234", []string{"198.51.100.1, 192.0.2.1", "10.1.1.1"}, "198.51.100.1"},
+		{"only proxies", "10.0.0.2:1234", []string{"10.0.0.3"}, "10.0.0.3"},
+		{"no header", "10.0.0.2:1234", nil, "10.0.0.2"},
+		{"garbage", "10.0.0.2:1234", []string{"198.51.100.1, unknown"}, "10.0.0.2"},
+		{"mapped", "[::ffff:10.0.0.2]:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		var got string
+		handler := TrustProxies(proxies)(func(w http.ResponseWriter, r *http.Request) {
+			got = ClientIP(r)
+		})
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		for _, v := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		handler(httptest.NewRecorder(), r)
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
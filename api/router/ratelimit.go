@@ -0,0 +1,138 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Holds a token bucket per key. The in-memory implementation only works for
+// a single instance, a shared backend can be plugged in by implementing this
+// interface.
+type RateLimitStore interface {
+	// Take a token from the key's bucket, which holds up to policy.Requests
+	// tokens and refills completely within policy.Per.
+	Take(key string, policy RateLimitPolicy) RateLimitStatus
+}
+
+// Clients may burst up to Requests requests, after that they get
+// Requests per Per, evenly spread.
+type RateLimitPolicy struct {
+	Requests int
+	Per      time.Duration
+}
+
+// Tokens per second.
+func (p RateLimitPolicy) rate() float64 {
+	return float64(p.Requests) / p.Per.Seconds()
+}
+
+// Outcome of taking a token.
+type RateLimitStatus struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next token, if not allowed
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	per    time.Duration
+}
+
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *memoryRateLimitStore) Take(key string, policy RateLimitPolicy) RateLimitStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+
+	size := float64(policy.Requests)
+	rate := policy.rate()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: size}
+		s.buckets[key] = b
+	} else {
+		b.tokens = min(size, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+	b.per = policy.Per
+
+	status := RateLimitStatus{Allowed: b.tokens >= 1}
+	if status.Allowed {
+		b.tokens--
+	} else {
+		status.RetryAfter = fromSeconds((1 - b.tokens) / rate)
+	}
+	status.Remaining = int(b.tokens)
+	status.Reset = fromSeconds((size - b.tokens) / rate)
+	return status
+}
+
+// Drop buckets that have been full for a while so the map does not grow
+// indefinitely.
+func (s *memoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	for key, b := range s.buckets {
+		if now.Sub(b.last) > b.per {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+func fromSeconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Limit the request rate per authenticated user, or per client IP for
+// anonymous requests. Every response carries the RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers, requests over the limit
+// are answered with 429 and a Retry-After header.
+//
+// Buckets are kept per name, so subtrees with different names are limited
+// independently. To key by user it needs to be registered before the
+// authentication middleware so it runs after it. Registered after it, it
+// rejects requests before they cost an authentication. Behind a proxy the
+// IP address is only right with TrustProxies in front.
+func RateLimit(store RateLimitStore, name string, policy RateLimitPolicy) Middleware {
+	limit := strconv.Itoa(policy.Requests)
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			key := name + ":ip:" + ClientIP(r)
+			if claims, ok := GetClaims(r); ok {
+				key = name + ":user:" + strconv.FormatInt(claims.ID, 10)
+			}
+			status := store.Take(key, policy)
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", limit)
+			h.Set("RateLimit-Remaining", strconv.Itoa(status.Remaining))
+			h.Set("RateLimit-Reset", retryAfter(status.Reset))
+			if !status.Allowed {
+				h.Set("Retry-After", retryAfter(status.RetryAfter))
+				WriteError(w, r, http.StatusTooManyRequests, errors.New("rate limit exceeded"))
+				return
+			}
+			next(w, r)
+		}
+	}
+}
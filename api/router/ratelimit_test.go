@@ -0,0 +1,73 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	policy := RateLimitPolicy{Requests: 2, Per: time.Minute}
+
+	for remaining := 1; remaining >= 0; remaining-- {
+		status := store.Take("a", policy)
+		if !status.Allowed || status.Remaining != remaining {
+			t.Errorf("burst: got %+v, want %d remaining", status, remaining)
+		}
+	}
+	status := store.Take("a", policy)
+	if status.Allowed || status.RetryAfter <= 0 || status.RetryAfter > 30*time.Second {
+		t.Errorf("over the limit: got %+v", status)
+	}
+	if status := store.Take("b", policy); !status.Allowed {
+		t.Error("other key limited")
+	}
+
+	// half the period refills one of the two tokens
+	store.buckets["a"].last = time.Now().Add(-30 * time.Second)
+	if status := store.Take("a", policy); !status.Allowed || status.Remaining != 0 {
+		t.Errorf("after refill: got %+v", status)
+	}
+
+	store.buckets["a"].last = time.Now().Add(-2 * time.Minute)
+	store.lastSweep = time.Now().Add(-time.Hour)
+	store.Take("b", policy)
+	if _, ok := store.buckets["a"]; ok {
+		t.Error("sweep kept a full bucket")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	handler := RateLimit(store, "api", RateLimitPolicy{Requests: 1, Per: 10 * time.Second})(func(w http.ResponseWriter, r *http.Request) {})
+	call := func(ip string, claims *Claims) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = ip + ":1234"
+		if claims != nil {
+			r = withClaims(r, claims)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		return rec
+	}
+
+	rec := call("10.0.0.1", nil)
+	h := rec.Header()
+	if rec.Code != http.StatusOK || h.Get("RateLimit-Limit") != "1" || h.Get("RateLimit-Remaining") != "0" || h.Get("RateLimit-Reset") != "10" {
+		t.Errorf("first request: got %d, headers %v", rec.Code, h)
+	}
+	rec = call("10.0.0.1", nil)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "10" {
+		t.Errorf("second request: got %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// users are limited on their own, regardless of their address
+	if rec := call("10.0.0.1", &Claims{ID: 1}); rec.Code != http.StatusOK {
+		t.Errorf("user: got %d", rec.Code)
+	}
+	if rec := call("10.0.0.2", &Claims{ID: 1}); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("user from another address: got %d", rec.Code)
+	}
+}
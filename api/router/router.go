@@ -1,11 +1,16 @@
 package router
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
+	"log/slog"
 	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
 )
 
 // Allows processing a request by returning only result, status code and error
@@ -16,25 +21,77 @@ type ProcessFunc[T any] func(*http.Request) (T, HttpStatus)
 // but without needing to interface with the response.
 type NoValueProcessFunc func(*http.Request) HttpStatus
 
+// Allows processing a request with its JSON body already decoded and validated.
+type BodyProcessFunc[In, Out any] func(*http.Request, In) (Out, HttpStatus)
+
+// Same as BodyProcessFunc but without returning a body in the response.
+type NoValueBodyProcessFunc[In any] func(*http.Request, In) HttpStatus
+
 type HttpStatus struct {
 	Code int
 	Err  error
 }
 
+// Handler created by Proc, Handle and their variants. Besides serving the
+// request it knows the types it reads and writes, which end up in the
+// OpenAPI document.
+type Endpoint struct {
+	http.HandlerFunc
+	input  reflect.Type // struct bound by Handle, nil otherwise
+	body   reflect.Type // JSON request body, nil if not read
+	output reflect.Type // JSON response body, nil if empty
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
 // Wrap a ProcessFunc in Proc to handle the error that might be returned
 // from HttpStatus that is return from a ProcessFunc without interfacing with
 // the response directly. JSON serialization is done automatically.
-func Proc[T any](p ProcessFunc[T]) http.HandlerFunc {
-	return process(p, true)
+func Proc[T any](p ProcessFunc[T]) Endpoint {
+	return Endpoint{HandlerFunc: process(p, true), output: typeOf[T]()}
 }
 
 // Same as Process but without returning a body in the response.
-func ProcEmpty(n NoValueProcessFunc) http.HandlerFunc {
+func ProcEmpty(n NoValueProcessFunc) Endpoint {
 	p := func(r *http.Request) (struct{}, HttpStatus) {
 		status := n(r)
 		return struct{}{}, status
 	}
-	return process(p, false)
+	return Endpoint{HandlerFunc: process(p, false)}
+}
+
+// Decode the JSON body into In and check it against its validation tags
+// before passing it on. Malformed bodies and failed validations are answered
+// with 400, bodies over MaxBodyBytes with 413, without calling the
+// BodyProcessFunc.
+func ProcBody[In, Out any](p BodyProcessFunc[In, Out]) Endpoint {
+	checkRules(typeOf[In]())
+	return Endpoint{HandlerFunc: process(withBody(p), true), body: typeOf[In](), output: typeOf[Out]()}
+}
+
+// Same as ProcBody but without returning a body in the response.
+func ProcBodyEmpty[In any](n NoValueBodyProcessFunc[In]) Endpoint {
+	checkRules(typeOf[In]())
+	p := func(r *http.Request, in In) (struct{}, HttpStatus) {
+		return struct{}{}, n(r, in)
+	}
+	return Endpoint{HandlerFunc: process(withBody(p), false), body: typeOf[In]()}
+}
+
+func withBody[In, Out any](p BodyProcessFunc[In, Out]) ProcessFunc[Out] {
+	return func(r *http.Request) (Out, HttpStatus) {
+		var in In
+		var zero Out
+		if status := DecodeBody(r, &in); status.Err != nil {
+			return zero, status
+		}
+		if err := Validate(in); err.Err() {
+			return zero, HttpStatus{Code: http.StatusBadRequest, Err: err}
+		}
+		return p(r, in)
+	}
 }
 
 func process[T any](p ProcessFunc[T], writeBody bool) http.HandlerFunc {
@@ -42,14 +99,17 @@ func process[T any](p ProcessFunc[T], writeBody bool) http.HandlerFunc {
 		result, status := p(r)
 		code := status.Code
 		if code >= 400 {
-			fmt.Printf("Error %d in %v: %s\n", status.Code, r.RequestURI, status.Err)
-			w.WriteHeader(code)
-			switch status.Err.(type) {
-			case ValidationErr:
-				io.WriteString(w, status.Err.Error())
-			}
+			WriteError(w, r, code, status.Err)
 			return
 		}
+		if v, ok := any(result).(Validated); ok && code < 300 {
+			validators := v.Validators()
+			validators.setHeaders(w.Header())
+			if validators.notModified(r) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
 		if writeBody {
 			w.Header().Add("Content-Type", "application/json")
 			w.WriteHeader(code)
@@ -62,7 +122,8 @@ func process[T any](p ProcessFunc[T], writeBody bool) http.HandlerFunc {
 
 type route struct {
 	path        string
-	handlers    map[string]http.HandlerFunc
+	fullPath    string
+	handlers    map[string]http.Handler
 	subroutes   []*route
 	middlewares []Middleware
 	registered  bool
@@ -72,7 +133,7 @@ type route struct {
 func New(path string) *route {
 	return &route{
 		path:        path,
-		handlers:    make(map[string]http.HandlerFunc),
+		handlers:    make(map[string]http.Handler),
 		subroutes:   make([]*route, 0),
 		middlewares: make([]Middleware, 0),
 		registered:  false,
@@ -80,30 +141,36 @@ func New(path string) *route {
 }
 
 // Registers GET method handler for the receiving route
-func (route *route) OnGet(handler http.HandlerFunc) {
+func (route *route) OnGet(handler http.Handler) {
 	route.addHandler("GET", handler)
 }
 
 // Registers PUT method handler for the receiving route
-func (route *route) OnPut(handler http.HandlerFunc) {
+func (route *route) OnPut(handler http.Handler) {
 	route.addHandler("PUT", handler)
 }
 
 // Registers POST method handler for the receiving route
-func (route *route) OnPost(handler http.HandlerFunc) {
+func (route *route) OnPost(handler http.Handler) {
 	route.addHandler("POST", handler)
 }
 
 // Registers DELETE method handler for the receiving route
-func (route *route) OnDelete(handler http.HandlerFunc) {
+func (route *route) OnDelete(handler http.Handler) {
 	route.addHandler("DELETE", handler)
 }
 
 // Registers PATCH method handler for the receiving route
-func (route *route) OnPatch(handler http.HandlerFunc) {
+func (route *route) OnPatch(handler http.Handler) {
 	route.addHandler("PATCH", handler)
 }
 
+// Registers a handler for an arbitrary method on the receiving route.
+// HEAD and OPTIONS are answered automatically unless registered here.
+func (route *route) OnMethod(method string, handler http.Handler) {
+	route.addHandler(strings.ToUpper(method), handler)
+}
+
 // Create a subroute starting at the receiving routes path.
 // The final route string is created by adding the parent's path
 // before that of the new subroute.
@@ -121,7 +188,7 @@ func (route *route) Use(m Middleware) {
 	route.middlewares = append(route.middlewares, m)
 }
 
-func (route *route) addHandler(method string, handler http.HandlerFunc) {
+func (route *route) addHandler(method string, handler http.Handler) {
 	if _, registered := route.handlers[method]; registered {
 		log.Fatalf(`Multiple %s handlers for path "%s"`, method, route.path)
 	}
@@ -135,8 +202,10 @@ func (route *route) registerHandlers(mux *http.ServeMux, prefixPath string) {
 		log.Fatalf(`Methods for path "%s" are registered multiple times`, fullPath)
 	}
 	route.registered = true
+	route.fullPath = fullPath
 
 	if len(route.handlers) != 0 {
+		allowed := route.allowedMethods()
 
 		fmt.Printf("| Registered methods for path %-25s", fullPath)
 		for method := range route.handlers {
@@ -145,18 +214,52 @@ func (route *route) registerHandlers(mux *http.ServeMux, prefixPath string) {
 		fmt.Println(" |")
 
 		handler := func(w http.ResponseWriter, r *http.Request) {
-			if handler, ok := route.handlers[r.Method]; ok {
-				handler(w, r)
-			} else {
-				w.WriteHeader(http.StatusMethodNotAllowed)
+			handler, ok := route.handlers[r.Method]
+			if !ok && r.Method == http.MethodHead {
+				// the server discards the body written for HEAD requests
+				handler, ok = route.handlers[http.MethodGet]
+			}
+			switch {
+			case ok:
+				handler.ServeHTTP(w, r)
+			case r.Method == http.MethodOptions:
+				w.Header().Set("Allow", strings.Join(allowed, ", "))
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				w.Header().Set("Allow", strings.Join(allowed, ", "))
+				WriteError(w, r, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
 			}
 		}
 
 		for _, middleware := range route.middlewares {
 			handler = middleware(handler)
 		}
+		if _, ok := route.handlers[http.MethodOptions]; !ok {
+			// answered before the middlewares, so it works without
+			// credentials, except for CORS preflights the CORS middleware answers
+			chain := handler
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodOptions || isPreflight(r) {
+					chain(w, r)
+					return
+				}
+				w.Header().Set("Allow", strings.Join(allowed, ", "))
+				w.WriteHeader(http.StatusNoContent)
+			}
+		}
+		handler = withRoute(&routeInfo{pattern: fullPath, allowed: allowed}, handler)
 
-		mux.HandleFunc(fullPath, handler)
+		pattern := fullPath
+		if fullPath == "/" {
+			// without the anchor every unmatched path would end up here
+			pattern = "/{$}"
+		}
+		// Patterns with a method only conflict with overlapping paths that
+		// share the method, so /todo/{id}/move may exist next to
+		// /todo/category/{id}. Other methods reach the fallback of NewServer.
+		for method := range route.handlers {
+			mux.HandleFunc(method+" "+pattern, handler)
+		}
 
 	} else if len(route.subroutes) == 0 {
 		log.Fatalf(`Path "%s" has no handlers`, fullPath)
@@ -168,14 +271,128 @@ func (route *route) registerHandlers(mux *http.ServeMux, prefixPath string) {
 	}
 }
 
+// Methods the route answers, including the ones handled automatically.
+func (route *route) allowedMethods() []string {
+	methods := make([]string, 0, len(route.handlers)+2)
+	for method := range route.handlers {
+		methods = append(methods, method)
+	}
+	if _, ok := route.handlers[http.MethodGet]; ok && !slices.Contains(methods, http.MethodHead) {
+		methods = append(methods, http.MethodHead)
+	}
+	if !slices.Contains(methods, http.MethodOptions) {
+		methods = append(methods, http.MethodOptions)
+	}
+	slices.Sort(methods)
+	return methods
+}
+
+// Methods handled by the route and its subroutes.
+func (route *route) methods(seen map[string]bool) {
+	for method := range route.handlers {
+		seen[method] = true
+	}
+	for _, sr := range route.subroutes {
+		sr.methods(seen)
+	}
+}
+
+// Answer requests no pattern matches. If a route serves the path with
+// another method, it answers itself with 405 or, for OPTIONS, its allowed
+// methods. Other paths get 404, passing through the route's middlewares so
+// these responses are logged and tagged like any other.
+func (route *route) fallback(mux *http.ServeMux, methods []string) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, r, http.StatusNotFound, fmt.Errorf("path %s not found", r.URL.Path))
+	}
+	for _, middleware := range route.middlewares {
+		handler = middleware(handler)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, method := range methods {
+			probe := r.WithContext(r.Context())
+			probe.Method = method
+			if h, pattern := mux.Handler(probe); pattern != "/" {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+var keyRoute = ctxKey{"route"}
+
+// Information about the route that matched a request.
+type routeInfo struct {
+	pattern string
+	allowed []string
+}
+
+func withRoute(info *routeInfo, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), keyRoute, info)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// Methods the matched route answers, for middlewares that need to know them
+// before the request reaches the route. Empty if no route matched.
+func AllowedMethods(r *http.Request) []string {
+	if info, ok := r.Context().Value(keyRoute).(*routeInfo); ok {
+		return info.allowed
+	}
+	return nil
+}
+
+// Path of the route that matched the request as registered, e.g.
+// '/api/todo/{id}'. Empty if no route matched.
+func RoutePattern(r *http.Request) string {
+	if info, ok := r.Context().Value(keyRoute).(*routeInfo); ok {
+		return info.pattern
+	}
+	return ""
+}
+
 // Registers the given handler and propagates middlewares to subroutes.
-// To avoid this propapation, create different base routes and attach them separately
-func ListenAndServe(addr string, routes ...*route) error {
+// To avoid this propapation, create different base routes and attach them separately.
+//
+// Paths no route matches are answered with 404. A route at "/" only matches
+// the root itself, other paths ending in a slash match everything below them.
+//
+// The returned server has conservative timeouts that may be adjusted
+// before it is started.
+func NewServer(addr string, routes ...*route) *http.Server {
 	mux := http.NewServeMux()
-	fmt.Println("Starting server on", addr)
+	root := New("/")
+	seen := make(map[string]bool)
 	for _, r := range routes {
 		r.registerHandlers(mux, "")
+		r.methods(seen)
+		if r.fullPath == "/" && len(r.handlers) != 0 {
+			root = r
+		}
+	}
+	methods := make([]string, 0, len(seen))
+	for method := range seen {
+		methods = append(methods, method)
 	}
+	slices.Sort(methods)
+	mux.HandleFunc("/", root.fallback(mux, methods))
 	fmt.Println()
-	return http.ListenAndServe(addr, mux)
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+}
+
+// Register the routes and serve them until the server fails.
+func ListenAndServe(addr string, routes ...*route) error {
+	srv := NewServer(addr, routes...)
+	slog.Info("Starting server", "addr", addr)
+	return srv.ListenAndServe()
 }
@@ -0,0 +1,96 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func ok(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", name)
+	}
+}
+
+func TestRouting(t *testing.T) {
+	base := New("/")
+	base.OnGet(ok("base"))
+	todo := base.Subroute("todo")
+	todoId := todo.Subroute("/{id}")
+	todoMove := todoId.Subroute("/move")
+	category := todo.Subroute("/category")
+	categoryId := category.Subroute("/{id}")
+	todoId.OnGet(ok("todo"))
+	todoMove.OnPost(ok("move"))
+	category.OnGet(ok("categories"))
+	categoryId.OnDelete(ok("category"))
+	handler := NewServer("", base).Handler
+
+	tests := []struct {
+		method, path string
+		code         int
+		handler      string
+		allow        string
+	}{
+		{"GET", "/", 200, "base", ""},
+		{"HEAD", "/", 200, "base", ""},
+		{"GET", "/todo/1", 200, "todo", ""},
+		{"POST", "/todo/1/move", 200, "move", ""},
+		{"GET", "/todo/category", 200, "categories", ""},
+		{"DELETE", "/todo/category/2", 200, "category", ""},
+		{"POST", "/todo/category/move", 200, "move", ""},
+		{"GET", "/todo/1/move", 405, "", "OPTIONS, POST"},
+		{"PUT", "/todo/category/2", 405, "", "DELETE, OPTIONS"},
+		{"OPTIONS", "/todo/1", 204, "", "GET, HEAD, OPTIONS"},
+		{"OPTIONS", "/todo/1/move", 204, "", "OPTIONS, POST"},
+		{"GET", "/missing", 404, "", ""},
+		{"POST", "/todo/1/2/3", 404, "", ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.path, rec.Code, tt.code)
+		}
+		if got := rec.Header().Get("X-Handler"); got != tt.handler {
+			t.Errorf("%s %s: served by %q, want %q", tt.method, tt.path, got, tt.handler)
+		}
+		if got := rec.Header().Get("Allow"); got != tt.allow {
+			t.Errorf("%s %s: got Allow %q, want %q", tt.method, tt.path, got, tt.allow)
+		}
+	}
+}
+
+func TestOptionsWithoutCredentials(t *testing.T) {
+	base := New("/")
+	base.Use(func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			WriteError(w, r, http.StatusUnauthorized, errors.New("not logged in"))
+		}
+	})
+	todo := base.Subroute("todo")
+	todo.OnGet(ok("todos"))
+	handler := NewServer("", base).Handler
+
+	request := func(r *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+	rec := request(httptest.NewRequest(http.MethodOptions, "/todo", nil))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Allow") != "GET, HEAD, OPTIONS" {
+		t.Errorf("OPTIONS: got %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+	if rec := request(httptest.NewRequest(http.MethodGet, "/todo", nil)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET: got %d", rec.Code)
+	}
+
+	// preflights are left to the middlewares, among them CORS
+	preflight := httptest.NewRequest(http.MethodOptions, "/todo", nil)
+	preflight.Header.Set("Origin", "https://app.example.com")
+	preflight.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	if rec := request(preflight); rec.Code != http.StatusUnauthorized {
+		t.Errorf("preflight: got %d", rec.Code)
+	}
+}
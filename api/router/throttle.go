@@ -0,0 +1,218 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Keeps track of failed attempts per key, e.g. a username or an IP address.
+// The in-memory implementation only works for a single instance, a shared
+// backend can be plugged in by implementing this interface.
+type AttemptStore interface {
+	// Time until the key may try again. Zero if the key is not locked.
+	Locked(key string) time.Duration
+	// Record a failed attempt and return the number of consecutive failures
+	// and how long the key is locked as a result.
+	Failed(key string) (int, time.Duration)
+	// Forget all failures of the key.
+	Succeeded(key string)
+}
+
+// Failures are free up to FreeAttempts. Every further failure locks the key
+// for BaseDelay, doubling with each failure up to MaxDelay.
+// Failures older than Window are forgotten.
+type ThrottlePolicy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	Window       time.Duration
+}
+
+var DefaultThrottlePolicy = ThrottlePolicy{
+	FreeAttempts: 5,
+	BaseDelay:    time.Second,
+	MaxDelay:     15 * time.Minute,
+	Window:       time.Hour,
+}
+
+// Lock duration after the given number of consecutive failures.
+func (p ThrottlePolicy) Delay(failures int) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return min(delay, p.MaxDelay)
+}
+
+type attempts struct {
+	failures    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// Upper bound on the keys kept in memory. Keys are chosen by clients, e.g.
+// any username, so without a bound the map could be grown at will.
+const maxAttemptKeys = 100_000
+
+type memoryAttemptStore struct {
+	policy    ThrottlePolicy
+	mu        sync.Mutex
+	keys      map[string]*attempts
+	lastSweep time.Time
+}
+
+func NewMemoryAttemptStore(policy ThrottlePolicy) *memoryAttemptStore {
+	return &memoryAttemptStore{
+		policy:    policy,
+		keys:      make(map[string]*attempts),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *memoryAttemptStore) Locked(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.keys[key]
+	if !ok {
+		return 0
+	}
+	return max(time.Until(a.lockedUntil), 0)
+}
+
+func (s *memoryAttemptStore) Failed(key string) (int, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+
+	a, ok := s.keys[key]
+	if !ok && len(s.keys) >= maxAttemptKeys {
+		s.evictOldest()
+	}
+	if !ok || now.Sub(a.last) > s.policy.Window {
+		a = &attempts{}
+		s.keys[key] = a
+	}
+	a.failures++
+	a.last = now
+	delay := s.policy.Delay(a.failures)
+	a.lockedUntil = now.Add(delay)
+	return a.failures, delay
+}
+
+func (s *memoryAttemptStore) Succeeded(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key)
+}
+
+// Drop keys without recent failures so the map does not grow indefinitely.
+func (s *memoryAttemptStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	for key, a := range s.keys {
+		if now.Sub(a.last) > s.policy.Window && now.After(a.lockedUntil) {
+			delete(s.keys, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+// Make room by forgetting the key whose last failure is the oldest.
+func (s *memoryAttemptStore) evictOldest() {
+	var oldest string
+	var last time.Time
+	for key, a := range s.keys {
+		if oldest == "" || a.last.Before(last) {
+			oldest, last = key, a.last
+		}
+	}
+	delete(s.keys, oldest)
+}
+
+// Count failed logins per client IP and per username taken from basic auth.
+// Locked clients are answered with 429 and a Retry-After header before the
+// credentials are checked. A response with status 401 counts as failure,
+// any successful response resets the username's failures.
+//
+// Needs to be registered after the authentication middleware so it runs first.
+func LoginThrottle(store AttemptStore) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			keys := []string{"ip:" + ClientIP(r)}
+			userKey := ""
+			if username, _, ok := r.BasicAuth(); ok {
+				userKey = "user:" + username
+				keys = append(keys, userKey)
+			}
+
+			var wait time.Duration
+			for _, key := range keys {
+				wait = max(wait, store.Locked(key))
+			}
+			if wait > 0 {
+				w.Header().Set("Retry-After", retryAfter(wait))
+				WriteError(w, r, http.StatusTooManyRequests, errors.New("too many failed attempts"))
+				return
+			}
+
+			rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+			next(rec, r)
+
+			switch {
+			case rec.code == http.StatusUnauthorized:
+				for _, key := range keys {
+					failures, locked := store.Failed(key)
+					if locked > 0 {
+						Logger(r).Warn("Login locked", "key", key, "failures", failures, "path", r.URL.Path, "locked", locked)
+					}
+				}
+			case rec.code < 400 && userKey != "":
+				store.Succeeded(userKey)
+			}
+		}
+	}
+}
+
+// Seconds until the given duration has passed, rounded up.
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
+
+// Remembers the status code and the number of bytes written by the wrapped handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+	bytes       int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.code = code
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Allows http.ResponseController to reach the original writer.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
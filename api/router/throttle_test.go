@@ -0,0 +1,105 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestThrottlePolicyDelay(t *testing.T) {
+	p := ThrottlePolicy{FreeAttempts: 2, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	want := []time.Duration{0, 0, 0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for failures, delay := range want {
+		if got := p.Delay(failures); got != delay {
+			t.Errorf("Delay(%d): got %v, want %v", failures, got, delay)
+		}
+	}
+}
+
+func TestMemoryAttemptStore(t *testing.T) {
+	store := NewMemoryAttemptStore(ThrottlePolicy{FreeAttempts: 1, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour})
+	if n, locked := store.Failed("alice"); n != 1 || locked != 0 {
+		t.Errorf("first failure: got %d, %v", n, locked)
+	}
+	if store.Locked("alice") != 0 {
+		t.Error("locked after a free attempt")
+	}
+	if n, locked := store.Failed("alice"); n != 2 || locked != time.Minute {
+		t.Errorf("second failure: got %d, %v", n, locked)
+	}
+	if wait := store.Locked("alice"); wait <= 0 || wait > time.Minute {
+		t.Errorf("got wait %v", wait)
+	}
+	if store.Locked("bob") != 0 {
+		t.Error("other key locked")
+	}
+	store.Succeeded("alice")
+	if store.Locked("alice") != 0 {
+		t.Error("still locked after success")
+	}
+}
+
+func TestMemoryAttemptStoreForgets(t *testing.T) {
+	store := NewMemoryAttemptStore(DefaultThrottlePolicy)
+	store.Failed("old")
+	store.keys["old"].last = time.Now().Add(-2 * DefaultThrottlePolicy.Window)
+	if n, _ := store.Failed("old"); n != 1 {
+		t.Errorf("failures outside the window counted, got %d", n)
+	}
+
+	store.keys["old"].last = time.Now().Add(-2 * DefaultThrottlePolicy.Window)
+	store.lastSweep = time.Now().Add(-time.Hour)
+	store.Failed("new")
+	if _, ok := store.keys["old"]; ok {
+		t.Error("sweep kept an expired key")
+	}
+
+	for i := len(store.keys); i < maxAttemptKeys; i++ {
+		store.keys[fmt.Sprint(i)] = &attempts{last: time.Now()}
+	}
+	store.keys["oldest"] = &attempts{last: time.Now().Add(-time.Minute)}
+	store.Failed("one more")
+	if len(store.keys) != maxAttemptKeys+1 {
+		t.Errorf("got %d keys", len(store.keys))
+	}
+	if _, ok := store.keys["oldest"]; ok {
+		t.Error("oldest key not evicted")
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	store := NewMemoryAttemptStore(ThrottlePolicy{FreeAttempts: 1, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour})
+	handler := LoginThrottle(store)(func(w http.ResponseWriter, r *http.Request) {
+		if _, password, _ := r.BasicAuth(); password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	login := func(user, password, ip string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+		r.SetBasicAuth(user, password)
+		r.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		return rec
+	}
+
+	if rec := login("alice", "secret", "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Fatalf("login: got %d", rec.Code)
+	}
+	login("alice", "wrong", "10.0.0.1")
+	login("alice", "wrong", "10.0.0.2")
+
+	rec := login("alice", "secret", "10.0.0.3")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("locked user: got %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := login("bob", "secret", "10.0.0.3"); rec.Code != http.StatusOK {
+		t.Errorf("other user: got %d", rec.Code)
+	}
+	login("carol", "wrong", "10.0.0.1")
+	if rec := login("dave", "secret", "10.0.0.1"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("locked IP: got %d", rec.Code)
+	}
+}
@@ -0,0 +1,54 @@
+package router
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("check42/api/router")
+
+// Start a server span for every request, named after method and route
+// template. Trace context sent by the client is continued, the span is
+// passed on in the request context so handlers can add child spans.
+//
+// Needs to be registered before RequestID so the span carries the request id.
+func Trace(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		route := RoutePattern(r)
+		name := r.Method
+		if route != "" {
+			name += " " + route
+		}
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("request.id", GetRequestID(r)),
+			),
+		)
+		defer span.End()
+
+		info, r := withRequestInfo(r.WithContext(ctx))
+		if sc := span.SpanContext(); sc.IsValid() {
+			info.traceID = sc.TraceID().String()
+		}
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next(rec, r)
+
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.code))
+		if info.userID != 0 {
+			span.SetAttributes(attribute.Int64("enduser.id", info.userID))
+		}
+		if rec.code >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.code))
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package router
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Validation rules are declared in the 'validate' struct tag as a comma
+// separated list. Fields are reported under their JSON name.
+//
+//	required     value must not be the zero value
+//	optional     nil pointers skip all other rules, including required
+//	min=N        at least N characters/elements, or a value of at least N for numbers
+//	max=N        at most N characters/elements, or a value of at most N for numbers
+//	range=N:M    number between N and M
+//	email        bare email address without display name
+//	enum=a|b|c   one of the listed values
+//	dive         validate the fields of a nested struct
+//	{name}       custom validator added through RegisterValidator
+//
+// Rules other than required are skipped for zero values, so optional fields
+// are only checked when they are set. Pointers are dereferenced.
+type Validator func(v reflect.Value) (hint string, ok bool)
+
+var (
+	validatorsMu sync.RWMutex
+	validators   = make(map[string]Validator)
+)
+
+// Make a custom validator available under the given tag name. Validators
+// have to be registered before the routes using them, as the tags are checked
+// when the routes are created.
+func RegisterValidator(name string, v Validator) {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	validators[name] = v
+}
+
+// Check the struct v against the rules in its tags.
+func Validate(v any) ValidationErr {
+	err := NewValidationErr()
+	val := reflect.ValueOf(v)
+	for val.Kind() == reflect.Pointer {
+		if val.IsNil() {
+			return err
+		}
+		val = val.Elem()
+	}
+	if val.Kind() == reflect.Struct {
+		validateStruct(val, "", err)
+	}
+	return err
+}
+
+func validateStruct(val reflect.Value, prefix string, err validationErr) {
+	t := val.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag, ok := field.Tag.Lookup("validate")
+		if !ok || tag == "-" {
+			continue
+		}
+		name := prefix + fieldName(field)
+		validateField(val.Field(i), name, strings.Split(tag, ","), err)
+	}
+}
+
+func validateField(v reflect.Value, name string, rules []string, err validationErr) {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			if !hasRule(rules, "optional") && hasRule(rules, "required") {
+				err.Hint(name, HintMissingOrZero)
+			}
+			return
+		}
+		v = v.Elem()
+	}
+
+	if v.IsZero() {
+		if hasRule(rules, "required") {
+			if v.Kind() == reflect.String {
+				err.Hint(name, HintEmptyString)
+			} else {
+				err.Hint(name, HintMissingOrZero)
+			}
+		}
+		return
+	}
+
+	for _, rule := range rules {
+		rule, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		var hint string
+		switch rule {
+		case "", "required", "optional":
+		case "min":
+			hint = checkBound(v, arg, true)
+		case "max":
+			hint = checkBound(v, arg, false)
+		case "range":
+			lo, hi, _ := strings.Cut(arg, ":")
+			if hint = checkBound(v, lo, true); hint == "" {
+				hint = checkBound(v, hi, false)
+			}
+			if hint != "" {
+				hint = fmt.Sprintf("should be between %s and %s", lo, hi)
+			}
+		case "email":
+			addr, e := mail.ParseAddress(v.String())
+			if v.Kind() != reflect.String || e != nil || addr.Address != v.String() {
+				hint = HintInvalidEmail
+			}
+		case "enum":
+			options := strings.Split(arg, "|")
+			if !containsValue(options, v) {
+				hint = "should be one of " + strings.Join(options, ", ")
+			}
+		case "dive":
+			if v.Kind() == reflect.Struct {
+				validateStruct(v, name+".", err)
+			}
+		default:
+			validatorsMu.RLock()
+			custom, ok := validators[rule]
+			validatorsMu.RUnlock()
+			if !ok {
+				panic(fmt.Sprintf(`unknown validation rule "%s" on field "%s"`, rule, name))
+			}
+			if h, ok := custom(v); !ok {
+				hint = h
+			}
+		}
+		if hint != "" {
+			err.Hint(name, hint)
+		}
+	}
+}
+
+// Compare length or numeric value against the bound. Returns a hint on violation.
+func checkBound(v reflect.Value, arg string, lower bool) string {
+	bound := parseBound(arg)
+	var actual float64
+	length := false
+	switch v.Kind() {
+	case reflect.String:
+		actual, length = float64(len([]rune(v.String()))), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		actual, length = float64(v.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		actual = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		actual = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		actual = v.Float()
+	default:
+		return ""
+	}
+
+	switch {
+	case lower && actual < bound && length && v.Kind() == reflect.String:
+		return fmt.Sprintf("should be at least %s characters long", arg)
+	case lower && actual < bound && length:
+		return fmt.Sprintf("should contain at least %s elements", arg)
+	case lower && actual < bound:
+		return fmt.Sprintf("should be at least %s", arg)
+	case !lower && actual > bound && length && v.Kind() == reflect.String:
+		return fmt.Sprintf("should be at most %s characters long", arg)
+	case !lower && actual > bound && length:
+		return fmt.Sprintf("should contain at most %s elements", arg)
+	case !lower && actual > bound:
+		return fmt.Sprintf("should be at most %s", arg)
+	}
+	return ""
+}
+
+func parseBound(arg string) float64 {
+	bound, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		panic(fmt.Sprintf(`invalid bound "%s" in validation rule`, arg))
+	}
+	return bound
+}
+
+var checkedTypes sync.Map
+
+// Panic if the validation tags of the struct t, or of the nested structs it
+// dives into, hold unknown rules or malformed arguments. Called when a route
+// is created, so such mistakes stop the server on startup instead of failing
+// the first request that reaches the route.
+func checkRules(t reflect.Type) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+	// stored before the fields are checked to stop at recursive types
+	if _, checked := checkedTypes.LoadOrStore(t, true); checked {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			checkedTypes.Delete(t)
+			panic(r)
+		}
+	}()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("validate")
+		if !field.IsExported() || !ok || tag == "-" {
+			continue
+		}
+		for _, rule := range strings.Split(tag, ",") {
+			rule, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+			switch rule {
+			case "", "required", "optional", "email":
+			case "min", "max":
+				parseBound(arg)
+			case "range":
+				lo, hi, ok := strings.Cut(arg, ":")
+				if !ok {
+					panic(fmt.Sprintf(`invalid range "%s" on field "%s.%s"`, arg, t.Name(), field.Name))
+				}
+				parseBound(lo)
+				parseBound(hi)
+			case "enum":
+				if arg == "" {
+					panic(fmt.Sprintf(`empty enum on field "%s.%s"`, t.Name(), field.Name))
+				}
+			case "dive":
+				checkRules(field.Type)
+			default:
+				validatorsMu.RLock()
+				_, ok := validators[rule]
+				validatorsMu.RUnlock()
+				if !ok {
+					panic(fmt.Sprintf(`unknown validation rule "%s" on field "%s.%s"`, rule, t.Name(), field.Name))
+				}
+			}
+		}
+	}
+}
+
+func containsValue(options []string, v reflect.Value) bool {
+	actual := fmt.Sprint(v.Interface())
+	for _, o := range options {
+		if o == actual {
+			return true
+		}
+	}
+	return false
+}
+
+func hasRule(rules []string, name string) bool {
+	for _, r := range rules {
+		if strings.TrimSpace(r) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Name of the field as it appears in JSON.
+func fieldName(f reflect.StructField) string {
+	if tag, ok := f.Tag.Lookup("json"); ok {
+		name, _, _ := strings.Cut(tag, ",")
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+	return f.Name
+}
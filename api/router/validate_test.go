@@ -0,0 +1,145 @@
+package router
+
+import (
+	"net/http"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+type address struct {
+	City string `json:"city" validate:"required"`
+	Zip  string `json:"zip"`
+}
+
+type signup struct {
+	Name    string   `json:"name" validate:"required,min=3,max=5"`
+	Email   string   `json:"email" validate:"email"`
+	Age     int      `json:"age" validate:"range=18:99"`
+	Role    string   `json:"role" validate:"enum=user|admin"`
+	Tags    []string `json:"tags" validate:"max=2"`
+	Nick    *string  `json:"nick" validate:"optional,required,min=2"`
+	Address address  `json:"address" validate:"dive"`
+	Note    string   `json:"-" validate:"max=1"`
+}
+
+func hints(v any) map[string][]string {
+	return Validate(v).(validationErr).hints
+}
+
+func TestValidate(t *testing.T) {
+	nick := "x"
+	got := hints(signup{
+		Name:    "ab",
+		Email:   "Alice <alice@example.com>",
+		Age:     17,
+		Role:    "root",
+		Tags:    []string{"a", "b", "c"},
+		Nick:    &nick,
+		Address: address{Zip: "10115"},
+		Note:    "too long",
+	})
+	want := map[string][]string{
+		"name":         {"should be at least 3 characters long"},
+		"email":        {HintInvalidEmail},
+		"age":          {"should be between 18 and 99"},
+		"role":         {"should be one of user, admin"},
+		"tags":         {"should contain at most 2 elements"},
+		"nick":         {"should be at least 2 characters long"},
+		"address.city": {HintEmptyString},
+		"Note":         {"should be at most 1 characters long"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got hints %v, want %v", got, want)
+	}
+
+	valid := signup{Name: "alice", Email: "alice@example.com", Age: 30, Role: "admin", Address: address{City: "Berlin"}}
+	if err := Validate(&valid); err.Err() {
+		t.Errorf("valid struct rejected: %v", err)
+	}
+	if err := Validate((*signup)(nil)); err.Err() {
+		t.Errorf("nil pointer rejected: %v", err)
+	}
+}
+
+func TestValidateRequired(t *testing.T) {
+	type input struct {
+		Count *int `json:"count" validate:"required"`
+		Limit int  `json:"limit" validate:"required,max=10"`
+	}
+	got := hints(input{})
+	want := map[string][]string{"count": {HintMissingOrZero}, "limit": {HintMissingOrZero}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got hints %v, want %v", got, want)
+	}
+}
+
+func TestCustomValidator(t *testing.T) {
+	RegisterValidator("even", func(v reflect.Value) (string, bool) {
+		return "should be even", v.Int()%2 == 0
+	})
+	type input struct {
+		N int `json:"n" validate:"even"`
+	}
+	checkRules(reflect.TypeOf(input{}))
+	if got := hints(input{N: 3}); !slices.Equal(got["n"], []string{"should be even"}) {
+		t.Errorf("got hints %v", got)
+	}
+	if err := Validate(input{N: 4}); err.Err() {
+		t.Errorf("even number rejected: %v", err)
+	}
+}
+
+func TestCheckRules(t *testing.T) {
+	type unknown struct {
+		A string `validate:"required,shiny"`
+	}
+	type badBound struct {
+		A string `validate:"max=ten"`
+	}
+	type badRange struct {
+		A int `validate:"range=5"`
+	}
+	type emptyEnum struct {
+		A string `validate:"enum="`
+	}
+	type nested struct {
+		Inner unknown `validate:"dive"`
+	}
+	for _, typ := range []reflect.Type{
+		reflect.TypeOf(unknown{}),
+		reflect.TypeOf(badBound{}),
+		reflect.TypeOf(badRange{}),
+		reflect.TypeOf(emptyEnum{}),
+		reflect.TypeOf(&nested{}),
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v: no panic", typ)
+				}
+			}()
+			checkRules(typ)
+		}()
+	}
+
+	// valid tags pass, as do recursive types and types without fields
+	type node struct {
+		Next *node `validate:"dive"`
+	}
+	checkRules(reflect.TypeOf(signup{}))
+	checkRules(reflect.TypeOf(node{}))
+	checkRules(reflect.TypeOf(0))
+}
+
+func TestProcBodyChecksRules(t *testing.T) {
+	type input struct {
+		A string `validate:"required,shiny"`
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("ProcBody accepted an unknown rule")
+		}
+	}()
+	ProcBodyEmpty(func(_ *http.Request, _ input) HttpStatus { return HttpStatus{} })
+}
@@ -13,6 +13,7 @@ const (
 	HintEmptyString     = "is left empty"
 	HintIncorrectFormat = "has incorrect format"
 	HintMinimumLength8  = "should be at least 8 characters long"
+	HintInvalidEmail    = "is not a valid email address"
 )
 
 type validationErr struct {
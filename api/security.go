@@ -2,11 +2,17 @@ package api
 
 import (
 	"check42/api/router"
+	"check42/model"
+	"check42/store/password"
 	"check42/store/stores"
+	"context"
 	"encoding/base64"
+	"log/slog"
 	"strings"
 
-	"golang.org/x/crypto/bcrypt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ApiAuthority struct {
@@ -15,46 +21,88 @@ type ApiAuthority struct {
 	pwSalt    string
 }
 
-func (a ApiAuthority) Authorize(scheme, payload string) (bool, *router.Claims) {
+var tracer = otel.Tracer("check42/api")
+
+func (a ApiAuthority) Authorize(ctx context.Context, scheme, payload string) (ok bool, claims *router.Claims) {
+	ctx, span := tracer.Start(ctx, "ApiAuthority.Authorize", trace.WithAttributes(attribute.String("auth.scheme", scheme)))
+	defer func() {
+		span.SetAttributes(attribute.Bool("auth.success", ok))
+		span.End()
+	}()
+
 	switch strings.ToLower(scheme) {
 	case "basic":
-		return a.validateBasicAuth(payload)
+		return a.validateBasicAuth(ctx, payload)
 	case "bearer":
-		return validateJWTAuth(payload, a.jwtSecret)
+		return a.validateJWTAuth(ctx, payload)
+	case "mtls":
+		return a.validateClientCert(ctx, payload)
 	}
 	return false, nil
 }
 
-func validateJWTAuth(payload string, secret []byte) (bool, *router.Claims) {
-	claims, err := router.ValidateJWT(payload, secret)
+// Besides the signature, the user is looked up on every request so that
+// disabled accounts, ended sessions and role changes take effect before the
+// token expires.
+func (a ApiAuthority) validateJWTAuth(ctx context.Context, payload string) (bool, *router.Claims) {
+	claims, err := router.ValidateJWT(payload, a.jwtSecret)
 	if err != nil {
 		return false, nil
 	}
+	user, err := a.store.GetUserByID(ctx, int(claims.ID))
+	if err != nil || user.Disabled || user.SessionVersion != claims.Session {
+		return false, nil
+	}
+	claims.Name = user.Name
+	claims.Role = user.Role
 	return true, claims
 }
 
-func (a ApiAuthority) validateBasicAuth(payload string) (bool, *router.Claims) {
+func (a ApiAuthority) validateBasicAuth(ctx context.Context, payload string) (bool, *router.Claims) {
 
 	username, password, success := decodeBasicAuth(payload)
 	if !success {
 		return false, nil
 	}
 
-	user, err := a.store.GetUserByName(username)
+	user, err := a.store.GetUserByName(ctx, username)
 	if err != nil {
 		return false, nil
 	}
+	if !user.EmailVerified || user.Disabled {
+		return false, nil
+	}
 
-	// no error means password was correct
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(username+password+a.pwSalt))
-	if err != nil {
+	if !a.checkPassword(ctx, user, password) {
+		return false, nil
+	}
+
+	return true, user.Claims()
+}
+
+// Internal services authenticate with a client certificate issued by the
+// configured CA. They act as the user named like the certificate's common
+// name, which has to exist and be enabled.
+func (a ApiAuthority) validateClientCert(ctx context.Context, commonName string) (bool, *router.Claims) {
+	if commonName == "" {
 		return false, nil
 	}
+	user, err := a.store.GetUserByName(ctx, commonName)
+	if err != nil || user.Disabled {
+		return false, nil
+	}
+	return true, user.Claims()
+}
 
-	return true, &router.Claims{
-		Name: user.Name,
-		ID:   user.ID,
+// Check the password and transparently replace outdated hashes on success.
+func (a ApiAuthority) checkPassword(ctx context.Context, user model.User, pw string) bool {
+	ok, rehash := password.Verify(user.PasswordHash, pw, user.Name, a.pwSalt)
+	if ok && rehash {
+		if err := a.store.SetPassword(ctx, user.ID, pw); err != nil {
+			slog.Error("Rehashing password failed", "user_id", user.ID, "err", err)
+		}
 	}
+	return ok
 }
 
 func decodeBasicAuth(payload string) (string, string, bool) {
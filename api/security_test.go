@@ -0,0 +1,38 @@
+package api
+
+import (
+	"check42/model"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEndedSessions(t *testing.T) {
+	user := model.User{ID: 1, Name: "alice", Role: model.RoleUser, SessionVersion: 2}
+	users := &fakeUsers{users: []model.User{user}}
+	s := server{users: users, auth: ApiAuthority{store: users, jwtSecret: []byte("jwt-secret")}}
+
+	// a session started now and one from before the sessions were ended
+	session := func(version int64) string {
+		u := user
+		u.SessionVersion = version
+		rec := httptest.NewRecorder()
+		if err := s.startSession(rec, httptest.NewRequest(http.MethodPost, "/auth/login", nil), u.Claims()); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Result().Cookies()[0].Value
+	}
+	current, ended := session(2), session(1)
+
+	if ok, claims := s.auth.Authorize(context.Background(), "bearer", current); !ok || claims.ID != user.ID {
+		t.Errorf("current session: got %v, %+v", ok, claims)
+	}
+	if ok, _ := s.auth.Authorize(context.Background(), "bearer", ended); ok {
+		t.Error("ended session accepted")
+	}
+	users.users[0].Disabled = true
+	if ok, _ := s.auth.Authorize(context.Background(), "bearer", current); ok {
+		t.Error("session of a disabled user accepted")
+	}
+}
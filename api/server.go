@@ -1,32 +1,59 @@
 package api
 
 import (
+	"check42/api/oidc"
 	rt "check42/api/router"
+	"check42/config"
+	"check42/mail"
+	"check42/model"
 	"check42/store/stores"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type server struct {
-	addr  string
-	todos stores.TodoStore
-	users stores.UserStore
+	publicURL     string
+	secureCookies bool
+	todos         stores.TodoStore
+	users         stores.UserStore
+	mailer        mail.Mailer
+	auth          ApiAuthority
+	oidc          map[string]*oidc.Provider
+	metrics       *todoMetrics
+	pendingLogins rt.AttemptStore
 }
 
-func RunServer(addr string, todos stores.TodoStore, users stores.UserStore) {
-	s := &server{addr, todos, users}
-
-	secret, found := os.LookupEnv("JWT_SECRET")
-	if !found {
-		log.Fatal("Fatal error: missing environment variable 'JWT_SECRET'")
-	}
+// Build the HTTP server for the API. It is not started yet so the caller
+// can adjust it and shut it down. ready reports whether the dependencies
+// needed to serve requests are reachable and is answered on /readyz.
+// The configuration is expected to be validated.
+func NewServer(cfg *config.Config, todos stores.TodoStore, users stores.UserStore, mailer mail.Mailer, ready ReadinessCheck) (*http.Server, error) {
+	providers := oidcProviders(cfg.OIDC)
 	authority := ApiAuthority{
 		store:     users,
-		jwtSecret: []byte(secret),
-		pwSalt:    os.Getenv("PW_SALT"),
+		jwtSecret: []byte(cfg.Auth.JWTSecret),
+		pwSalt:    cfg.Auth.PasswordSalt,
+	}
+
+	s := &server{
+		publicURL:     strings.TrimSuffix(cfg.Server.PublicURL, "/"),
+		secureCookies: cfg.TLS.Enabled() || strings.HasPrefix(cfg.Server.PublicURL, "https://"),
+		todos:         todos,
+		users:         users,
+		mailer:        mailer,
+		auth:          authority,
+		oidc:          providers,
+		metrics:       newTodoMetrics(prometheus.DefaultRegisterer),
+		pendingLogins: rt.NewMemoryAttemptStore(pendingLoginPolicy),
 	}
 
 	// routes
@@ -37,35 +64,113 @@ func RunServer(addr string, todos stores.TodoStore, users stores.UserStore) {
 	signin := auth.Subroute("/signin")
 	login := auth.Subroute("/login")
 	logout := auth.Subroute("/logout")
+	login2fa := auth.Subroute("/2fa")
+	verify := auth.Subroute("/verify")
+	verifyConfirm := verify.Subroute("/confirm")
+	forgot := auth.Subroute("/forgot")
+	reset := auth.Subroute("/reset")
+	oidcLogin := auth.Subroute("/oidc/{provider}")
+	oidcCallback := oidcLogin.Subroute("/callback")
 
 	api := base.Subroute("api")
 	todo := api.Subroute("/todo")
 	todoId := todo.Subroute("/{id}")
+	todoMove := todoId.Subroute("/move")
 	category := todo.Subroute("/category")
 	categoryId := category.Subroute("/{id}")
+	me := api.Subroute("/me")
+	mePassword := me.Subroute("/password")
+	twoFactor := api.Subroute("/2fa")
+	twoFactorConfirm := twoFactor.Subroute("/confirm")
+
+	admin := base.Subroute("admin")
+	adminUsers := admin.Subroute("/users")
+	adminUserDisable := adminUsers.Subroute("/{id}/disable")
+	adminUserEnable := adminUsers.Subroute("/{id}/enable")
+	adminUserReset := adminUsers.Subroute("/{id}/reset-password")
+	adminStats := admin.Subroute("/stats")
+
+	// separate base so the documentation is reachable without logging in
+	docs := rt.New("/api")
+	openapi := docs.Subroute("/openapi.json")
+	docsViewer := docs.Subroute("/docs")
+
+	// probes are polled frequently, so they skip logging and tracing
+	healthz := rt.New("/healthz")
+	readyz := rt.New("/readyz")
 
 	// middlewares
+	attempts := rt.NewMemoryAttemptStore(rt.DefaultThrottlePolicy)
+	limits := rt.NewMemoryRateLimitStore()
+	cors, hasCORS := corsPolicy(cfg.CORS)
+	// origins CORS lets send credentials must pass the CSRF check as well
+	trusted := append(origins(cfg.CSRF.TrustedOrigins), cors.CredentialedOrigins()...)
+	base.Use(rt.TrustProxies(cfg.Server.Proxies()))
+	base.Use(rt.CSRFProtect(trusted...))
+	if hasCORS {
+		base.Use(rt.CORS(cors))
+		docs.Use(rt.CORS(cors))
+	}
+	metrics := rt.NewMetrics(prometheus.DefaultRegisterer)
+	prometheus.MustRegister(newUserStatsCollector(users))
+	base.Use(rt.Recover)
+	base.Use(metrics.Instrument)
+	base.Use(rt.Trace)
 	base.Use(rt.LogCall)
+	base.Use(rt.RequestID)
+	docs.Use(rt.Recover)
+	docs.Use(metrics.Instrument)
+	docs.Use(rt.Trace)
+	docs.Use(rt.LogCall)
+	docs.Use(rt.RequestID)
+	healthz.Use(rt.Recover)
+	readyz.Use(rt.Recover)
 	login.Use(rt.BasicAuth(authority))
+	login.Use(rt.LoginThrottle(attempts))
+	login2fa.Use(rt.LoginThrottle(attempts))
+	if cfg.Limits.Auth.Requests > 0 {
+		auth.Use(rt.RateLimit(limits, "auth", rateLimitPolicy(cfg.Limits.Auth)))
+	}
 	api.Use(rt.JWTAuth(authority))
+	api.Use(rt.ClientCertAuth(authority))
+	admin.Use(rt.RequireRole(model.RoleAdmin))
+	admin.Use(rt.JWTAuth(authority))
+	admin.Use(rt.ClientCertAuth(authority))
+	// limited before authentication, so throttled requests don't reach the database
+	if cfg.Limits.API.Requests > 0 {
+		api.Use(rt.RateLimit(limits, "api", rateLimitPolicy(cfg.Limits.API)))
+		admin.Use(rt.RateLimit(limits, "admin", rateLimitPolicy(cfg.Limits.API)))
+	}
 
 	// handlers
-	signin.OnPost(s.handleSignin)
+	signin.OnPost(http.HandlerFunc(s.handleSignin))
 
-	login.OnPost(s.handleLogin)
+	login.OnPost(http.HandlerFunc(s.handleLogin))
 
-	logout.OnPost(s.handleLogout)
+	login2fa.OnPost(http.HandlerFunc(s.handleLogin2FA))
 
-	base.OnGet(handleBase)
-	assets.OnGet(handleStatic)
+	logout.OnPost(http.HandlerFunc(s.handleLogout))
 
-	todo.OnPost(rt.Proc(s.handlePostTodo))
-	todo.OnGet((rt.Proc(s.handleGetTodos)))
+	verify.OnGet(http.HandlerFunc(handleVerifyPage))
+	verify.OnPost(http.HandlerFunc(s.handleResendVerification))
+	verifyConfirm.OnPost(http.HandlerFunc(s.handleVerifyEmail))
+	forgot.OnPost(http.HandlerFunc(s.handleForgotPassword))
+	reset.OnPost(http.HandlerFunc(s.handleResetPassword))
 
-	todoId.OnGet(rt.Proc(s.handleGetTodo))
-	todoId.OnDelete(rt.ProcEmpty(s.handleDeleteTodo))
-	todoId.OnPut(rt.ProcEmpty(s.handlePutTodo))
-	todoId.OnPatch(rt.ProcEmpty(s.handlePatchTodo))
+	oidcLogin.OnGet(http.HandlerFunc(s.handleOIDCLogin))
+	oidcCallback.OnGet(http.HandlerFunc(s.handleOIDCCallback))
+
+	base.OnGet(http.HandlerFunc(handleBase))
+	assets.OnGet(http.HandlerFunc(handleStatic))
+
+	todo.OnPost(rt.Handle(s.handlePostTodo))
+	todo.OnGet(rt.Handle(s.handleGetTodos))
+
+	todoId.OnGet(rt.Handle(s.handleGetTodo))
+	todoId.OnDelete(rt.HandleEmpty(s.handleDeleteTodo))
+	todoId.OnPut(rt.HandleEmpty(s.handlePutTodo))
+	todoId.OnPatch(rt.HandleEmpty(s.handlePatchTodo))
+	todoMove.OnPost(rt.HandleEmpty(s.handleMoveTodos))
 
 	category.OnGet(rt.Proc(s.handleGetCategories))
 	category.OnPost(rt.Proc(s.handlePostCategory))
@@ -73,7 +178,103 @@ func RunServer(addr string, todos stores.TodoStore, users stores.UserStore) {
 	categoryId.OnPatch(rt.ProcEmpty(s.handlePatchCategory))
 	categoryId.OnDelete(rt.ProcEmpty(s.handleDeleteCategory))
 
-	log.Fatal(rt.ListenAndServe(s.addr, base))
+	me.OnGet(rt.Proc(s.handleGetMe))
+	me.OnPatch(rt.ProcBody(s.handlePatchMe))
+	me.OnDelete(http.HandlerFunc(s.handleDeleteMe))
+	mePassword.OnPost(rt.ProcBodyEmpty(s.handleChangePassword))
+
+	adminUsers.OnGet(rt.Proc(s.handleGetUsers))
+	adminUserDisable.OnPost(rt.ProcEmpty(s.handleDisableUser))
+	adminUserEnable.OnPost(rt.ProcEmpty(s.handleEnableUser))
+	adminUserReset.OnPost(rt.ProcEmpty(s.handleForcePasswordReset))
+	adminStats.OnGet(rt.Proc(s.handleGetStats))
+
+	twoFactor.OnPost(rt.Proc(s.handleEnroll2FA))
+	twoFactor.OnDelete(rt.ProcEmpty(s.handleDisable2FA))
+	twoFactorConfirm.OnPost(rt.Proc(s.handleConfirm2FA))
+
+	openapi.OnGet(rt.OpenAPI(rt.OpenAPIInfo{
+		Title:       "check42",
+		Version:     "1.0",
+		Description: "Simple REST-API for creating and managing todos",
+	}, base))
+	docsViewer.OnGet(http.HandlerFunc(handleDocs))
+
+	healthz.OnGet(rt.Proc(handleHealth))
+	readyz.OnGet(rt.Proc(handleReady(ready)))
+
+	srv := rt.NewServer(cfg.Server.Addr(), base, docs, healthz, readyz)
+	if cfg.TLS.Enabled() {
+		var err error
+		if srv.TLSConfig, err = tlsConfig(cfg.TLS); err != nil {
+			return nil, err
+		}
+	}
+	return srv, nil
+}
+
+// Serve the Prometheus metrics on their own listener, as they are not
+// meant for the public and carry no authentication.
+func NewMetricsServer(addr string) *http.Server {
+	metrics := rt.New("/metrics")
+	metrics.Use(rt.Recover)
+	metrics.Use(rt.RequestID)
+	metrics.OnGet(promhttp.Handler())
+	return rt.NewServer(addr, metrics)
+}
+
+// TLS settings for serving HTTPS directly. Renewed certificates are picked
+// up without a restart. With a client CA file clients may present a
+// certificate signed by one of its CAs to authenticate.
+func tlsConfig(cfg config.TLS) (*tls.Config, error) {
+	certs, err := rt.NewCertReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+// Policy for cross-origin requests, false if no origins are configured.
+func corsPolicy(cfg config.CORS) (rt.CORSPolicy, bool) {
+	if len(cfg.AllowedOrigins) == 0 {
+		return rt.CORSPolicy{}, false
+	}
+	return rt.CORSPolicy{
+		AllowedOrigins:   origins(cfg.AllowedOrigins),
+		ExposedHeaders:   []string{"X-Request-ID", "Retry-After", "Allow", "ETag", "Last-Modified", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"},
+		AllowCredentials: cfg.AllowCredentials,
+		MaxAge:           cfg.MaxAge,
+	}, true
+}
+
+func rateLimitPolicy(cfg config.RateLimit) rt.RateLimitPolicy {
+	return rt.RateLimitPolicy{Requests: cfg.Requests, Per: cfg.Per}
+}
+
+// Origins are compared without a trailing slash.
+func origins(configured []string) []string {
+	origins := make([]string, 0, len(configured))
+	for _, o := range configured {
+		origins = append(origins, strings.TrimSuffix(o, "/"))
+	}
+	return origins
 }
 
 // GET /
@@ -85,12 +286,24 @@ func handleBase(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, string(html))
 }
 
+// Viewer for the OpenAPI document.
+//
+// GET /api/docs
+func handleDocs(w http.ResponseWriter, r *http.Request) {
+	html, err := os.ReadFile("static/docs/index.html")
+	if err != nil {
+		fail(w, r, http.StatusNotFound, "file not found")
+		return
+	}
+	io.WriteString(w, string(html))
+}
+
 // GET /static/{path}
 func handleStatic(w http.ResponseWriter, r *http.Request) {
 	path, ok := strings.CutPrefix(r.URL.Path, "/static/")
 	file, err := os.ReadFile("./static/" + path)
 	if !ok || err != nil {
-		fail(w, 404, "file not found")
+		fail(w, r, 404, "file not found")
 		return
 	}
 	io.WriteString(w, string(file))
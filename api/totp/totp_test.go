@@ -0,0 +1,75 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// SHA1 secret of the RFC 6238 test vectors, base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidateRFCVectors(t *testing.T) {
+	// the RFC lists 8 digit codes, 6 digit codes are their last digits
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, v := range vectors {
+		step, ok := Validate(rfcSecret, v.code, time.Unix(v.unix, 0))
+		if !ok || step != v.unix/period {
+			t.Errorf("Validate(%s) at %d: got step %d, %t", v.code, v.unix, step, ok)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	at := time.Unix(1111111109, 0)
+	for _, offset := range []time.Duration{-period * time.Second, period * time.Second} {
+		step, ok := Validate(rfcSecret, "081804", at.Add(offset))
+		if !ok || step != at.Unix()/period {
+			t.Errorf("offset %v: got step %d, %t", offset, step, ok)
+		}
+	}
+	if _, ok := Validate(rfcSecret, "081804", at.Add(2*period*time.Second)); ok {
+		t.Error("code accepted two periods later")
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	at := time.Unix(1111111109, 0)
+	for _, tt := range []struct{ name, secret, code string }{
+		{"wrong code", rfcSecret, "081805"},
+		{"too short", rfcSecret, "81804"},
+		{"too long", rfcSecret, "0081804"},
+		{"invalid secret", "not base32!", "081804"},
+	} {
+		if _, ok := Validate(tt.secret, tt.code, at); ok {
+			t.Errorf("%s: accepted", tt.name)
+		}
+	}
+	if _, ok := Validate(strings.ToLower(rfcSecret), " 081804 ", at); !ok {
+		t.Error("lower case secret and surrounding spaces rejected")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret := GenerateSecret()
+	if secret == GenerateSecret() {
+		t.Error("secrets repeat")
+	}
+	key, err := encoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Errorf("secret %q decodes to %d bytes, %v", secret, len(key), err)
+	}
+	uri := ProvisioningURI("check42", "alice@example.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/check42:alice@example.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("unexpected provisioning URI %s", uri)
+	}
+}
@@ -0,0 +1,269 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// All settings of the application. Load fills them from defaults, a YAML
+// file, the environment and command line flags, in increasing precedence.
+type Config struct {
+	Server   Server              `yaml:"server"`
+	Database Database            `yaml:"database"`
+	Auth     Auth                `yaml:"auth"`
+	TLS      TLS                 `yaml:"tls"`
+	CORS     CORS                `yaml:"cors"`
+	CSRF     CSRF                `yaml:"csrf"`
+	Mail     Mail                `yaml:"mail"`
+	Log      Log                 `yaml:"log"`
+	Tracing  Tracing             `yaml:"tracing"`
+	Limits   Limits              `yaml:"rate_limit"`
+	OIDC     map[string]Provider `yaml:"oidc"`
+}
+
+type Server struct {
+	Host           string   `yaml:"host"`
+	Port           string   `yaml:"port"`
+	PublicURL      string   `yaml:"public_url"`      // base of links in mails
+	RedirectAddr   string   `yaml:"redirect_addr"`   // plain HTTP listener redirecting to HTTPS
+	MetricsAddr    string   `yaml:"metrics_addr"`    // listener for /metrics, disabled if empty
+	TrustedProxies []string `yaml:"trusted_proxies"` // reverse proxies whose X-Forwarded-For is believed
+}
+
+// Address the server listens on.
+func (s Server) Addr() string {
+	return net.JoinHostPort(s.Host, s.Port)
+}
+
+// The trusted proxies as ranges, single addresses cover just themselves.
+// Entries that don't parse are left out, Validate reports them.
+func (s Server) Proxies() []netip.Prefix {
+	proxies := make([]netip.Prefix, 0, len(s.TrustedProxies))
+	for _, p := range s.TrustedProxies {
+		if prefix, ok := parseProxy(p); ok {
+			proxies = append(proxies, prefix)
+		}
+	}
+	return proxies
+}
+
+func parseProxy(s string) (netip.Prefix, bool) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), true
+	}
+	prefix, err := netip.ParsePrefix(s)
+	return prefix.Masked(), err == nil
+}
+
+type Database struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+	Retries  int    `yaml:"retries"`
+}
+
+// Address of the MySQL server.
+func (d Database) Addr() string {
+	return net.JoinHostPort(d.Host, d.Port)
+}
+
+type Auth struct {
+	JWTSecret    string `yaml:"jwt_secret"`
+	PasswordSalt string `yaml:"password_salt"` // only needed to verify legacy hashes
+}
+
+// Serving HTTPS is enabled by setting certificate and key.
+type TLS struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"` // enables client certificate authentication
+}
+
+func (t TLS) Enabled() bool {
+	return t.CertFile != ""
+}
+
+type CORS struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins"`
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"`
+}
+
+type CSRF struct {
+	TrustedOrigins []string `yaml:"trusted_origins"`
+}
+
+// Mails are sent through SMTP if a host is set and printed to stdout otherwise.
+type Mail struct {
+	SMTPHost     string `yaml:"smtp_host"`
+	SMTPPort     string `yaml:"smtp_port"`
+	SMTPUser     string `yaml:"smtp_user"`
+	SMTPPassword string `yaml:"smtp_password"`
+	From         string `yaml:"from"`
+}
+
+type Log struct {
+	Level  slog.Level `yaml:"level"`
+	Format string     `yaml:"format"` // 'text' or 'json'
+}
+
+type Tracing struct {
+	Exporter string `yaml:"exporter"` // 'otlp', 'stdout' or 'none'
+	Endpoint string `yaml:"endpoint"` // OTLP/HTTP traces URL, the exporter's default if empty
+}
+
+// Requests allowed per subtree, as a burst and spread over the period.
+// Zero requests disable the limit.
+type Limits struct {
+	API  RateLimit `yaml:"api"`  // per IP address, /api and /admin
+	Auth RateLimit `yaml:"auth"` // per IP address, /auth
+}
+
+type RateLimit struct {
+	Requests int           `yaml:"requests"`
+	Per      time.Duration `yaml:"per"`
+}
+
+// An OpenID Connect provider users can sign in with.
+type Provider struct {
+	Issuer       string   `yaml:"issuer"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+// Settings used when neither file, environment nor flags set them.
+func Default() Config {
+	return Config{
+		Server: Server{
+			Port:        "2442",
+			PublicURL:   "http://localhost:2442",
+			MetricsAddr: "localhost:9242",
+		},
+		Database: Database{
+			Host:    "localhost",
+			Port:    "3306",
+			Name:    "check42",
+			Retries: 15,
+		},
+		CORS: CORS{
+			MaxAge: 10 * time.Minute,
+		},
+		Mail: Mail{
+			SMTPPort: "587",
+		},
+		Log: Log{
+			Level:  slog.LevelInfo,
+			Format: "text",
+		},
+		Limits: Limits{
+			API:  RateLimit{Requests: 300, Per: time.Minute},
+			Auth: RateLimit{Requests: 30, Per: time.Minute},
+		},
+	}
+}
+
+// Check that all required settings are present and usable. Every problem
+// is reported, naming the setting together with its environment variable.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", describe(key), fmt.Sprintf(format, args...)))
+	}
+
+	if !validPort(c.Server.Port) {
+		fail("server.port", "invalid port '%s'", c.Server.Port)
+	}
+	if u, err := url.Parse(c.Server.PublicURL); err != nil || u.Scheme == "" || u.Host == "" {
+		fail("server.public_url", "'%s' is not an absolute URL", c.Server.PublicURL)
+	}
+	if c.Server.RedirectAddr != "" && !c.TLS.Enabled() {
+		fail("server.redirect_addr", "redirecting to HTTPS requires tls.cert_file and tls.key_file")
+	}
+	for _, p := range c.Server.TrustedProxies {
+		if _, ok := parseProxy(p); !ok {
+			fail("server.trusted_proxies", "'%s' is neither an IP address nor a CIDR range", p)
+		}
+	}
+
+	if c.Database.Host == "" {
+		fail("database.host", "required")
+	}
+	if !validPort(c.Database.Port) {
+		fail("database.port", "invalid port '%s'", c.Database.Port)
+	}
+	if c.Database.User == "" {
+		fail("database.user", "required")
+	}
+	if c.Database.Name == "" {
+		fail("database.name", "required")
+	}
+	if c.Database.Retries < 1 {
+		fail("database.retries", "has to be at least 1")
+	}
+
+	if c.Auth.JWTSecret == "" {
+		fail("auth.jwt_secret", "required")
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		fail("tls.cert_file", "has to be set together with tls.key_file")
+	}
+	if c.TLS.ClientCAFile != "" && !c.TLS.Enabled() {
+		fail("tls.client_ca_file", "client certificates require tls.cert_file and tls.key_file")
+	}
+
+	if c.CORS.AllowCredentials && slices.Contains(c.CORS.AllowedOrigins, "*") {
+		fail("cors.allow_credentials", "can't be combined with the origin '*'")
+	}
+	if c.CORS.MaxAge < 0 {
+		fail("cors.max_age", "can't be negative")
+	}
+	if slices.Contains(c.CSRF.TrustedOrigins, "*") {
+		fail("csrf.trusted_origins", "trusting every origin disables the CSRF protection")
+	}
+
+	if c.Mail.SMTPHost != "" && !validPort(c.Mail.SMTPPort) {
+		fail("mail.smtp_port", "invalid port '%s'", c.Mail.SMTPPort)
+	}
+
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		fail("log.format", "'%s' is neither 'text' nor 'json'", c.Log.Format)
+	}
+	switch c.Tracing.Exporter {
+	case "otlp", "stdout", "none":
+	default:
+		fail("tracing.exporter", "'%s' is not one of 'otlp', 'stdout' and 'none'", c.Tracing.Exporter)
+	}
+
+	for key, limit := range map[string]RateLimit{"rate_limit.api": c.Limits.API, "rate_limit.auth": c.Limits.Auth} {
+		if limit.Requests < 0 {
+			fail(key+".requests", "can't be negative")
+		}
+		if limit.Requests > 0 && limit.Per <= 0 {
+			fail(key+".per", "has to be positive")
+		}
+	}
+
+	for name, p := range c.OIDC {
+		if p.Issuer == "" || p.ClientID == "" || p.RedirectURL == "" {
+			errs = append(errs, fmt.Errorf("oidc provider '%s': issuer, client_id and redirect_url are required", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n < 1<<16
+}
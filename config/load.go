@@ -0,0 +1,273 @@
+package config
+
+import (
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A setting that can be given through the environment and a flag.
+// The flag is named after the key in the YAML file.
+type binding struct {
+	key   string
+	env   string
+	usage string
+	set   func(string) error
+}
+
+func (c *Config) bindings() []binding {
+	return []binding{
+		{"server.host", "SERVER_HOST", "interface to listen on", str(&c.Server.Host)},
+		{"server.port", "SERVER_PORT", "port to listen on", str(&c.Server.Port)},
+		{"server.public_url", "PUBLIC_URL", "URL the server is reachable at, used in mails", str(&c.Server.PublicURL)},
+		{"server.redirect_addr", "HTTP_REDIRECT_ADDR", "address of a plain HTTP listener redirecting to HTTPS", str(&c.Server.RedirectAddr)},
+		{"server.metrics_addr", "METRICS_ADDR", "address of the Prometheus metrics listener, disabled if empty", str(&c.Server.MetricsAddr)},
+		{"server.trusted_proxies", "TRUSTED_PROXIES", "comma separated addresses or CIDR ranges of proxies setting X-Forwarded-For", list(&c.Server.TrustedProxies)},
+
+		{"database.host", "DB_HOST", "MySQL host", str(&c.Database.Host)},
+		{"database.port", "DB_PORT", "MySQL port", str(&c.Database.Port)},
+		{"database.user", "DB_USER", "MySQL user", str(&c.Database.User)},
+		{"database.password", "DB_PASSWORD", "MySQL password", str(&c.Database.Password)},
+		{"database.name", "DB_NAME", "MySQL database", str(&c.Database.Name)},
+		{"database.retries", "DB_RETRIES", "connection attempts before giving up", integer(&c.Database.Retries)},
+
+		{"auth.jwt_secret", "JWT_SECRET", "secret signing the session tokens", str(&c.Auth.JWTSecret)},
+		{"auth.password_salt", "PW_SALT", "salt of legacy password hashes", str(&c.Auth.PasswordSalt)},
+
+		{"tls.cert_file", "TLS_CERT_FILE", "PEM certificate chain for serving HTTPS", str(&c.TLS.CertFile)},
+		{"tls.key_file", "TLS_KEY_FILE", "PEM key for serving HTTPS", str(&c.TLS.KeyFile)},
+		{"tls.client_ca_file", "TLS_CLIENT_CA_FILE", "PEM CAs accepted for client certificates", str(&c.TLS.ClientCAFile)},
+
+		{"cors.allowed_origins", "CORS_ALLOWED_ORIGINS", "comma separated origins of cross-origin browser apps", list(&c.CORS.AllowedOrigins)},
+		{"cors.allow_credentials", "CORS_ALLOW_CREDENTIALS", "allow credentialed cross-origin requests", boolean(&c.CORS.AllowCredentials)},
+		{"cors.max_age", "CORS_MAX_AGE", "how long browsers may cache preflights, in seconds or as duration", seconds(&c.CORS.MaxAge)},
+
+		{"csrf.trusted_origins", "CSRF_TRUSTED_ORIGINS", "comma separated origins that may send cookie authenticated requests", list(&c.CSRF.TrustedOrigins)},
+
+		{"mail.smtp_host", "SMTP_HOST", "SMTP server, mails are printed to stdout if empty", str(&c.Mail.SMTPHost)},
+		{"mail.smtp_port", "SMTP_PORT", "SMTP port", str(&c.Mail.SMTPPort)},
+		{"mail.smtp_user", "SMTP_USER", "SMTP username", str(&c.Mail.SMTPUser)},
+		{"mail.smtp_password", "SMTP_PASSWORD", "SMTP password", str(&c.Mail.SMTPPassword)},
+		{"mail.from", "MAIL_FROM", "sender address, check42@<smtp host> if empty", str(&c.Mail.From)},
+
+		{"log.level", "LOG_LEVEL", "one of 'debug', 'info', 'warn' and 'error'", text(&c.Log.Level)},
+		{"log.format", "LOG_FORMAT", "'text' or 'json'", str(&c.Log.Format)},
+
+		{"tracing.exporter", "OTEL_TRACES_EXPORTER", "'otlp', 'stdout' or 'none'", str(&c.Tracing.Exporter)},
+		{"tracing.endpoint", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTLP/HTTP URL traces are sent to", str(&c.Tracing.Endpoint)},
+
+		{"rate_limit.api.requests", "RATE_LIMIT_API_REQUESTS", "requests per IP address to /api and /admin, 0 disables the limit", integer(&c.Limits.API.Requests)},
+		{"rate_limit.api.per", "RATE_LIMIT_API_PER", "period of the API limit, in seconds or as duration", seconds(&c.Limits.API.Per)},
+		{"rate_limit.auth.requests", "RATE_LIMIT_AUTH_REQUESTS", "requests per IP address to /auth, 0 disables the limit", integer(&c.Limits.Auth.Requests)},
+		{"rate_limit.auth.per", "RATE_LIMIT_AUTH_PER", "period of the auth limit, in seconds or as duration", seconds(&c.Limits.Auth.Per)},
+	}
+}
+
+// Build the configuration. Settings are taken from, in increasing precedence:
+// the defaults, the YAML file named by -config or CONFIG_FILE, the
+// environment and the flags in args. The result is validated.
+//
+// Empty environment variables clear string settings and are ignored for
+// numbers, booleans and durations.
+func Load(args []string) (*Config, error) {
+	c := Default()
+	bindings := c.bindings()
+
+	// flags are applied last but parsed first, as they may name the file
+	fs := flag.NewFlagSet("check42", flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML file with settings (CONFIG_FILE)")
+	var flagged []func() error
+	for _, b := range bindings {
+		fs.Func(b.key, b.usage+" ("+b.env+")", func(v string) error {
+			flagged = append(flagged, func() error {
+				if err := b.set(v); err != nil {
+					return fmt.Errorf("flag -%s: %w", b.key, err)
+				}
+				return nil
+			})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *file != "" {
+		if err := c.loadFile(*file); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, b := range bindings {
+		if v, ok := os.LookupEnv(b.env); ok {
+			if err := b.set(v); err != nil {
+				return nil, fmt.Errorf("%s: %w", b.env, err)
+			}
+		}
+	}
+	if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" && c.Tracing.Endpoint == "" {
+		c.Tracing.Endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	c.loadOIDCEnv()
+
+	for _, apply := range flagged {
+		if err := apply(); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Tracing.Exporter == "" {
+		c.Tracing.Exporter = "stdout"
+		if c.Tracing.Endpoint != "" {
+			c.Tracing.Exporter = "otlp"
+		}
+	}
+	if c.Mail.From == "" && c.Mail.SMTPHost != "" {
+		c.Mail.From = "check42@" + c.Mail.SMTPHost
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *Config) loadFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", name, err)
+	}
+	return nil
+}
+
+// OIDC_PROVIDERS holds a comma separated list of names, each of which is
+// configured through OIDC_{NAME}_ISSUER, _CLIENT_ID, _CLIENT_SECRET,
+// _REDIRECT_URL and _SCOPES. Variables override a provider from the file.
+func (c *Config) loadOIDCEnv() {
+	var names []string
+	list(&names)(os.Getenv("OIDC_PROVIDERS"))
+	for _, name := range names {
+		if c.OIDC == nil {
+			c.OIDC = make(map[string]Provider)
+		}
+		p := c.OIDC[name]
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		for _, field := range []struct {
+			suffix string
+			dst    *string
+		}{
+			{"ISSUER", &p.Issuer},
+			{"CLIENT_ID", &p.ClientID},
+			{"CLIENT_SECRET", &p.ClientSecret},
+			{"REDIRECT_URL", &p.RedirectURL},
+		} {
+			if v := os.Getenv(prefix + field.suffix); v != "" {
+				*field.dst = v
+			}
+		}
+		if scopes := os.Getenv(prefix + "SCOPES"); scopes != "" {
+			p.Scopes = strings.Fields(scopes)
+		}
+		c.OIDC[name] = p
+	}
+}
+
+// Name a setting the way users can set it, e.g. 'auth.jwt_secret (JWT_SECRET)'.
+func describe(key string) string {
+	for _, b := range (&Config{}).bindings() {
+		if b.key == key {
+			return key + " (" + b.env + ")"
+		}
+	}
+	return key
+}
+
+func str(p *string) func(string) error {
+	return func(v string) error {
+		*p = v
+		return nil
+	}
+}
+
+// Comma separated values, surrounding spaces and empty entries are dropped.
+func list(p *[]string) func(string) error {
+	return func(v string) error {
+		values := make([]string, 0)
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+		*p = values
+		return nil
+	}
+}
+
+func integer(p *int) func(string) error {
+	return func(v string) error {
+		if v == "" {
+			return nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a number", v)
+		}
+		*p = n
+		return nil
+	}
+}
+
+func boolean(p *bool) func(string) error {
+	return func(v string) error {
+		if v == "" {
+			return nil
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a boolean", v)
+		}
+		*p = b
+		return nil
+	}
+}
+
+// A plain number of seconds or a duration like '10m'.
+func seconds(p *time.Duration) func(string) error {
+	return func(v string) error {
+		if v == "" {
+			return nil
+		}
+		if n, err := strconv.Atoi(v); err == nil {
+			*p = time.Duration(n) * time.Second
+			return nil
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("'%s' is neither seconds nor a duration", v)
+		}
+		*p = d
+		return nil
+	}
+}
+
+func text(p encoding.TextUnmarshaler) func(string) error {
+	return func(v string) error {
+		if v == "" {
+			return nil
+		}
+		return p.UnmarshalText([]byte(v))
+	}
+}
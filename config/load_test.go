@@ -0,0 +1,188 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Start from an environment without any of the settings, restored afterwards.
+func clearEnv(t *testing.T) {
+	names := []string{"CONFIG_FILE", "OTEL_EXPORTER_OTLP_ENDPOINT", "OIDC_PROVIDERS"}
+	for _, b := range (&Config{}).bindings() {
+		names = append(names, b.env)
+	}
+	for _, name := range names {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	name := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// Settings Validate insists on, so the cases only need to name what they test.
+var required = map[string]string{"DB_USER": "check42", "JWT_SECRET": "secret"}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{"default", "", nil, nil, "2442"},
+		{"file", "server:\n  port: 3000\n", nil, nil, "3000"},
+		{"env over file", "server:\n  port: 3000\n", map[string]string{"SERVER_PORT": "4000"}, nil, "4000"},
+		{"flag over env", "server:\n  port: 3000\n", map[string]string{"SERVER_PORT": "4000"}, []string{"-server.port", "5000"}, "5000"},
+		{"flag over default", "", nil, []string{"-server.port=5000"}, "5000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range required {
+				t.Setenv(k, v)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, tt.file)}, args...)
+			}
+			c, err := Load(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Server.Port != tt.want {
+				t.Errorf("got port %s, want %s", c.Server.Port, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadEmptyEnv(t *testing.T) {
+	clearEnv(t)
+	for k, v := range required {
+		t.Setenv(k, v)
+	}
+	t.Setenv("CONFIG_FILE", writeFile(t, `
+server:
+  metrics_addr: ":9242"
+database:
+  retries: 3
+cors:
+  allowed_origins: ["https://app.example.com"]
+  allow_credentials: true
+  max_age: 1m
+`))
+	for _, name := range []string{"METRICS_ADDR", "DB_RETRIES", "CORS_ALLOWED_ORIGINS", "CORS_ALLOW_CREDENTIALS", "CORS_MAX_AGE", "LOG_LEVEL"} {
+		t.Setenv(name, "")
+	}
+	c, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{"string cleared", c.Server.MetricsAddr, ""},
+		{"list cleared", len(c.CORS.AllowedOrigins), 0},
+		{"number kept", c.Database.Retries, 3},
+		{"boolean kept", c.CORS.AllowCredentials, true},
+		{"duration kept", c.CORS.MaxAge, time.Minute},
+		{"level kept", c.Log.Level, Default().Log.Level},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadInvalidValues(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{map[string]string{"DB_RETRIES": "many"}, nil, "DB_RETRIES: 'many' is not a number"},
+		{map[string]string{"CORS_ALLOW_CREDENTIALS": "maybe"}, nil, "CORS_ALLOW_CREDENTIALS: 'maybe' is not a boolean"},
+		{map[string]string{"CORS_MAX_AGE": "soon"}, nil, "CORS_MAX_AGE: 'soon' is neither seconds nor a duration"},
+		{nil, []string{"-database.retries", "x"}, "flag -database.retries: 'x' is not a number"},
+	}
+	for _, tt := range tests {
+		clearEnv(t)
+		for k, v := range required {
+			t.Setenv(k, v)
+		}
+		for k, v := range tt.env {
+			t.Setenv(k, v)
+		}
+		if _, err := Load(tt.args); err == nil || err.Error() != tt.want {
+			t.Errorf("%v %v: got error %v, want %q", tt.env, tt.args, err, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() Config {
+		c := Default()
+		c.Database.User = "check42"
+		c.Auth.JWTSecret = "secret"
+		c.Tracing.Exporter = "none"
+		return c
+	}
+	if err := (&Config{}).Validate(); err == nil {
+		t.Error("empty config passed")
+	}
+	if c := valid(); c.Validate() != nil {
+		t.Fatalf("valid config failed: %v", c.Validate())
+	}
+
+	tests := []struct {
+		change func(*Config)
+		want   string
+	}{
+		{func(c *Config) { c.Server.Port = "70000" }, "server.port (SERVER_PORT): invalid port '70000'"},
+		{func(c *Config) { c.Server.PublicURL = "localhost" }, "server.public_url (PUBLIC_URL): 'localhost' is not an absolute URL"},
+		{func(c *Config) { c.Server.RedirectAddr = ":80" }, "server.redirect_addr (HTTP_REDIRECT_ADDR): redirecting to HTTPS requires tls.cert_file and tls.key_file"},
+		{func(c *Config) { c.Server.TrustedProxies = []string{"10.0.0.0/8", "proxy"} }, "server.trusted_proxies (TRUSTED_PROXIES): 'proxy' is neither an IP address nor a CIDR range"},
+		{func(c *Config) { c.Database.User = "" }, "database.user (DB_USER): required"},
+		{func(c *Config) { c.Database.Retries = 0 }, "database.retries (DB_RETRIES): has to be at least 1"},
+		{func(c *Config) { c.Auth.JWTSecret = "" }, "auth.jwt_secret (JWT_SECRET): required"},
+		{func(c *Config) { c.TLS.KeyFile = "key.pem" }, "tls.cert_file (TLS_CERT_FILE): has to be set together with tls.key_file"},
+		{func(c *Config) { c.CORS.AllowedOrigins = []string{"*"}; c.CORS.AllowCredentials = true }, "cors.allow_credentials (CORS_ALLOW_CREDENTIALS): can't be combined with the origin '*'"},
+		{func(c *Config) { c.CSRF.TrustedOrigins = []string{"*"} }, "csrf.trusted_origins (CSRF_TRUSTED_ORIGINS): trusting every origin disables the CSRF protection"},
+		{func(c *Config) { c.Log.Format = "xml" }, "log.format (LOG_FORMAT): 'xml' is neither 'text' nor 'json'"},
+		{func(c *Config) { c.Tracing.Exporter = "zipkin" }, "tracing.exporter (OTEL_TRACES_EXPORTER): 'zipkin' is not one of 'otlp', 'stdout' and 'none'"},
+		{func(c *Config) { c.Limits.Auth.Per = 0 }, "rate_limit.auth.per (RATE_LIMIT_AUTH_PER): has to be positive"},
+		{func(c *Config) { c.OIDC = map[string]Provider{"idp": {Issuer: "https://idp"}} }, "oidc provider 'idp': issuer, client_id and redirect_url are required"},
+	}
+	for _, tt := range tests {
+		c := valid()
+		tt.change(&c)
+		if err := c.Validate(); err == nil || err.Error() != tt.want {
+			t.Errorf("got error %v, want %q", err, tt.want)
+		}
+	}
+
+	// all problems are reported at once
+	c := valid()
+	c.Database.User = ""
+	c.Auth.JWTSecret = ""
+	err := c.Validate()
+	if err == nil || len(strings.Split(err.Error(), "\n")) != 2 {
+		t.Errorf("got error %v, want both settings", err)
+	}
+}
@@ -0,0 +1,51 @@
+package mail
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Delivers plain text messages to a single recipient.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// Sends mails through an SMTP server. Authentication is only attempted
+// when a username is set.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m SMTPMailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	msg := strings.Builder{}
+	msg.WriteString("From: " + m.From + "\r\n")
+	msg.WriteString("To: " + to + "\r\n")
+	msg.WriteString("Subject: " + subject + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	addr := net.JoinHostPort(m.Host, m.Port)
+	return smtp.SendMail(addr, auth, m.From, []string{to}, []byte(msg.String()))
+}
+
+// Prints mails to stdout instead of sending them. Meant for local development.
+type LogMailer struct{}
+
+func (LogMailer) Send(to, subject, body string) error {
+	fmt.Printf("--- Mail to %s ---\nSubject: %s\n\n%s\n---\n", to, subject, body)
+	return nil
+}
@@ -2,16 +2,34 @@ package main
 
 import (
 	"check42/api"
+	rt "check42/api/router"
+	"check42/config"
+	"check42/mail"
 	"check42/store/stores"
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 var logo string = `
@@ -23,53 +41,188 @@ var logo string = `
  ╚═════╝╚═╝  ╚═╝╚══════╝ ╚═════╝╚═╝  ╚═╝      ╚═╝╚══════╝
 `
 
+// How long running requests may take to finish after SIGTERM.
+const shutdownTimeout = 20 * time.Second
+
 func main() {
 	godotenv.Load()
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("Invalid configuration:\n", err)
+	}
+	slog.SetDefault(newLogger(cfg.Log))
 
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
+	shutdownTracing, err := setupTracing(context.Background(), cfg.Tracing)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	config := mysql.Config{
-		User:      os.Getenv("DB_USER"),
-		Passwd:    os.Getenv("DB_PASSWORD"),
+	dbConfig := mysql.Config{
+		User:      cfg.Database.User,
+		Passwd:    cfg.Database.Password,
 		Net:       "tcp",
-		Addr:      dbHost + ":" + dbPort,
-		DBName:    "check42",
+		Addr:      cfg.Database.Addr(),
+		DBName:    cfg.Database.Name,
 		ParseTime: true,
 	}
+	db, err := connectWithRetries(dbConfig, cfg.Database.Retries)
 
-	maxTries, err := strconv.ParseInt(os.Getenv("DB_RETRIES"), 10, 10)
 	if err != nil {
-		log.Fatal("missing .env file")
+		log.Fatal(err)
 	}
-	db, err := connectWithRetries(config, int(maxTries))
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "check42"))
+	slog.Info("Connected to database", "addr", dbConfig.Addr)
+
+	todos := stores.TraceTodoStore(stores.NewMySQLTodoStore(db))
+	users := stores.TraceUserStore(stores.NewMySQLUserStore(db))
+
+	fmt.Println(logo)
 
+	srv, err := api.NewServer(cfg, todos, users, newMailer(cfg.Mail), db.PingContext)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal("Fatal error: ", err)
 	}
-	defer db.Close()
-	fmt.Println("Connection successful")
 
-	todos := stores.NewMySQLTodoStore(db)
-	users := stores.NewMySQLUserStore(db)
+	go func() {
+		slog.Info("Starting server", "addr", srv.Addr, "tls", srv.TLSConfig != nil)
+		var err error
+		if srv.TLSConfig != nil {
+			// certificates come from srv.TLSConfig.GetCertificate
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
 
-	fmt.Println(logo)
+	// plain HTTP listener sending clients over to HTTPS
+	var redirect *http.Server
+	if cfg.Server.RedirectAddr != "" {
+		redirect = &http.Server{
+			Addr:              cfg.Server.RedirectAddr,
+			Handler:           rt.RedirectToHTTPS(srv.Addr),
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		go func() {
+			slog.Info("Redirecting to HTTPS", "addr", redirect.Addr)
+			if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	// metrics are kept off the public listener
+	var metrics *http.Server
+	if cfg.Server.MetricsAddr != "" {
+		metrics = api.NewMetricsServer(cfg.Server.MetricsAddr)
+		go func() {
+			slog.Info("Serving metrics", "addr", metrics.Addr)
+			if err := metrics.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+
+	// stop accepting connections and wait for running requests
+	slog.Info("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if redirect != nil {
+		redirect.Shutdown(ctx)
+	}
+	if metrics != nil {
+		metrics.Shutdown(ctx)
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("Draining requests failed", "err", err)
+	}
+	if err := db.Close(); err != nil {
+		slog.Error("Closing database failed", "err", err)
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Flushing traces failed", "err", err)
+	}
+}
 
-	host := os.Getenv("SERVER_HOST")
-	port := os.Getenv("SERVER_PORT")
+// Structured logger writing to stderr in text or JSON format.
+func newLogger(cfg config.Log) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.Level}
+	if cfg.Format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
 
-	api.RunServer(host+":"+port, todos, users)
+// Export traces over OTLP/HTTP, print them to stdout or disable tracing,
+// depending on the configured exporter. The returned function flushes the
+// remaining spans.
+func setupTracing(ctx context.Context, cfg config.Tracing) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if cfg.Exporter == "none" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	if cfg.Exporter == "otlp" {
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	} else {
+		slog.Info("No OTLP endpoint configured, traces are printed to stdout")
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", "check42")))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Use SMTP when a host is configured and fall back to printing mails to stdout.
+func newMailer(cfg config.Mail) mail.Mailer {
+	if cfg.SMTPHost == "" {
+		slog.Info("No SMTP host configured, mails are printed to stdout")
+		return mail.LogMailer{}
+	}
+	return mail.SMTPMailer{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUser,
+		Password: cfg.SMTPPassword,
+		From:     cfg.From,
+	}
 }
 
 func connectWithRetries(config mysql.Config, maxTries int) (*sql.DB, error) {
 	tries := 1
-	for tries < maxTries {
+	for tries <= maxTries {
 		db, err := sql.Open("mysql", config.FormatDSN())
 		if err != nil {
 			return nil, err
 		}
 		if err := db.Ping(); err != nil {
-			fmt.Printf("Connection to database failed. Retrying (%d/%d)\n", tries, maxTries)
+			slog.Warn("Connection to database failed, retrying", "try", tries, "max_tries", maxTries, "err", err)
 			time.Sleep(3 * time.Second)
 			tries++
 			continue
@@ -2,34 +2,80 @@ package model
 
 import (
 	"check42/api/router"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
 	"time"
 )
 
+// Version starts at 1 and is incremented by every update.
 type Todo struct {
 	ID       int64        `json:"id"`
 	Owner    int64        `json:"owner"`
 	Text     string       `json:"text"`
 	Done     bool         `json:"done"`
 	Created  time.Time    `json:"created"`
+	Updated  time.Time    `json:"updated"`
+	Version  int64        `json:"version"`
 	Category TodoCategory `json:"category"`
 }
 
+// The ETag covers the category as well, as renaming it changes the todo's
+// representation without touching the todo.
+func (t Todo) Validators() router.Validators {
+	return router.Validators{
+		ETag:         fmt.Sprintf(`"%d-%d-%d"`, t.Version, t.Category.ID, t.Category.Version),
+		LastModified: t.Updated,
+	}
+}
+
+// All todos of a user. The ETag changes whenever a todo or one of their
+// categories is created, changed or deleted. There is no Last-Modified, as
+// the most recent change of the remaining todos misses deletions.
+type TodoList []Todo
+
+func (l TodoList) Validators() router.Validators {
+	hash := sha256.New()
+	for _, t := range l {
+		binary.Write(hash, binary.BigEndian, [4]int64{t.ID, t.Version, t.Category.ID, t.Category.Version})
+	}
+	return router.Validators{
+		ETag: strconv.Quote(hex.EncodeToString(hash.Sum(nil)[:16])),
+	}
+}
+
 type CreateTodo struct {
 	Owner    int64        `json:"owner"`
-	Text     string       `json:"text"`
+	Text     string       `json:"text" validate:"required,max=140"`
 	Done     bool         `json:"done"`
 	Category TodoCategory `json:"category"`
 }
 
+// Category 0 takes the todos out of their categories. Todos lists further
+// todos to move along with the one in the path.
+type MoveTodos struct {
+	Category int64   `json:"category"`
+	Todos    []int64 `json:"todos" validate:"max=99"`
+}
+
+type CreateCategory struct {
+	Name string `json:"name" validate:"required,max=140"`
+}
+
 type TodoCategory struct {
-	ID   int64  `json:"id"`
-	Name string `json:"name"`
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Version int64  `json:"version,omitempty"`
+}
+
+type TodoStats struct {
+	Todos      int `json:"todos"`
+	DoneTodos  int `json:"doneTodos"`
+	Categories int `json:"categories"`
 }
 
 func (t CreateTodo) ValidateNew() router.ValidationErr {
-	err := router.NewValidationErr()
-	if t.Text == "" {
-		err.Hint("text", router.HintEmptyString)
-	}
-	return err
+	return router.Validate(t)
 }
@@ -6,29 +6,110 @@ import (
 )
 
 type User struct {
-	ID           int64
-	Name         string
-	Email        string
-	PasswordHash string
-	Created      time.Time
+	ID            int64
+	Name          string
+	Email         string
+	PasswordHash  string
+	Created       time.Time
+	EmailVerified bool
+	Role          string
+	Disabled      bool
+	TOTPSecret    string
+	TOTPEnabled   bool
+	// Sessions are only valid for the version they were started with,
+	// raising it ends all of them.
+	SessionVersion int64
 }
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type CreateUser struct {
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Name     string `json:"name" validate:"required,max=140"`
+	Email    string `json:"email" validate:"required,email,max=50"`
+	Password string `json:"password" validate:"required,min=8"`
+}
+
+// Claims of a session for the user.
+func (u User) Claims() *router.Claims {
+	return &router.Claims{ID: u.ID, Name: u.Name, Role: u.Role, Session: u.SessionVersion}
 }
 
 func (u CreateUser) Validate() router.ValidationErr {
-	err := router.NewValidationErr()
-	if u.Email == "" {
-		err.Hint("email", router.HintEmptyString)
-	}
-	if u.Name == "" {
-		err.Hint("name", router.HintEmptyString)
-	}
-	if len(u.Password) < 8 {
-		err.Hint("password", router.HintMinimumLength8)
+	return router.Validate(u)
+}
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// Public view of a user's own account
+type Profile struct {
+	ID            int64     `json:"id"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"emailVerified"`
+	TOTPEnabled   bool      `json:"totpEnabled"`
+	Role          string    `json:"role"`
+	Disabled      bool      `json:"disabled"`
+	Created       time.Time `json:"created"`
+}
+
+func (u User) Profile() Profile {
+	return Profile{
+		ID:            u.ID,
+		Name:          u.Name,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		TOTPEnabled:   u.TOTPEnabled,
+		Role:          u.Role,
+		Disabled:      u.Disabled,
+		Created:       u.Created,
 	}
-	return err
+}
+
+type UserStats struct {
+	Users         int `json:"users"`
+	DisabledUsers int `json:"disabledUsers"`
+	Admins        int `json:"admins"`
+}
+
+type SystemStats struct {
+	UserStats
+	TodoStats
+}
+
+// Fields left out are not changed. Changing the name of a user with a legacy
+// password hash requires the current password.
+type UpdateProfile struct {
+	Name     *string `json:"name" validate:"optional,required,max=140"`
+	Email    *string `json:"email" validate:"optional,required,email,max=50"`
+	Password string  `json:"password"`
+}
+
+type PasswordChange struct {
+	OldPassword string `json:"oldPassword" validate:"required"`
+	NewPassword string `json:"newPassword" validate:"required,min=8"`
+}
+
+// Purposes of the single-use tokens sent out via mail
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+)
+
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+type PasswordReset struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8"`
+}
+
+func (p PasswordReset) Validate() router.ValidationErr {
+	return router.Validate(p)
 }
@@ -8,15 +8,48 @@ create table if not exists `user` (
     `email` varchar(50) not null,
     `password_hash` varchar(255) not null,
     `created` datetime default current_timestamp,
+    `email_verified` boolean not null default 0,
+    `role` varchar(20) not null default "user",
+    `disabled` boolean not null default 0,
+    `totp_secret` varchar(64) null,
+    `totp_enabled` boolean not null default 0,
+    `totp_last_step` bigint not null default 0,
+    `session_version` int not null default 1,
     primary key (`id`),
     unique (`name`),
     unique (`email`)
 );
 
+create table if not exists `user_identity` (
+    `provider` varchar(50) not null,
+    `subject` varchar(255) not null,
+    `user` int not null,
+    `created` datetime default current_timestamp,
+    primary key (`provider`, `subject`),
+    foreign key (`user`) references `user` (`id`) on delete cascade
+);
+
+create table if not exists `user_token` (
+    `token_hash` char(64) not null,
+    `user` int not null,
+    `purpose` varchar(20) not null,
+    `expires` datetime not null,
+    primary key (`token_hash`),
+    foreign key (`user`) references `user` (`id`) on delete cascade
+);
+
+create table if not exists `recovery_code` (
+    `user` int not null,
+    `code_hash` char(64) not null,
+    primary key (`user`, `code_hash`),
+    foreign key (`user`) references `user` (`id`) on delete cascade
+);
+
 create table if not exists `todo_category` (
 	`id` 	int not null auto_increment,
     `name` varchar(140) default "New category",
     `owner` int not null,
+    `version` int not null default 1,
     primary key (`id`),
     foreign key (`owner`) references `user` (`id`)
 );
@@ -27,14 +60,16 @@ create table if not exists `todo` (
     `text`      varchar(140),
     `done`      boolean default 0,
     `created`   datetime default current_timestamp,
+    `updated`   datetime default current_timestamp on update current_timestamp,
+    `version`   int not null default 1,
     `category`  int null,
     primary key (`id`),
     foreign key (`owner`) references `user` (`id`),
     foreign key (`category`) references `todo_category` (`id`) on delete cascade
 );
 
-insert into `user` (`name`, `email`, `password_hash`) values
-    ("admin", "admin@adm.in", "$2a$10$vPibycoXtT9WGUAEHrF/LeU.X2GM3UC4/mx8av2o63M5rXtQgDsw2");
+insert into `user` (`name`, `email`, `password_hash`, `email_verified`, `role`) values
+    ("admin", "admin@adm.in", "$2a$10$vPibycoXtT9WGUAEHrF/LeU.X2GM3UC4/mx8av2o63M5rXtQgDsw2", 1, "admin");
 
 insert into `todo_category` (`name`, `owner`) values
     ("At home", 1),
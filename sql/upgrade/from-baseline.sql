@@ -0,0 +1,53 @@
+-- Brings a database created by an older initdb.sql, with only the id, name,
+-- email, password_hash and created columns on `user`, up to the current
+-- schema. Run it once before starting the new version. It lives in its own
+-- directory so the MySQL image does not run it on new databases.
+
+use `check42`;
+
+alter table `user`
+    add column `email_verified` boolean not null default 0,
+    add column `role` varchar(20) not null default "user",
+    add column `disabled` boolean not null default 0,
+    add column `totp_secret` varchar(64) null,
+    add column `totp_enabled` boolean not null default 0,
+    add column `totp_last_step` bigint not null default 0,
+    add column `session_version` int not null default 1;
+
+-- accounts that could log in before email verification existed keep doing so
+update `user` set `email_verified` = 1;
+
+-- the seeded demo account administrates, as on new databases
+update `user` set `role` = 'admin' where `name` = 'admin';
+
+create table if not exists `user_identity` (
+    `provider` varchar(50) not null,
+    `subject` varchar(255) not null,
+    `user` int not null,
+    `created` datetime default current_timestamp,
+    primary key (`provider`, `subject`),
+    foreign key (`user`) references `user` (`id`) on delete cascade
+);
+
+create table if not exists `user_token` (
+    `token_hash` char(64) not null,
+    `user` int not null,
+    `purpose` varchar(20) not null,
+    `expires` datetime not null,
+    primary key (`token_hash`),
+    foreign key (`user`) references `user` (`id`) on delete cascade
+);
+
+create table if not exists `recovery_code` (
+    `user` int not null,
+    `code_hash` char(64) not null,
+    primary key (`user`, `code_hash`),
+    foreign key (`user`) references `user` (`id`) on delete cascade
+);
+
+alter table `todo_category`
+    add column `version` int not null default 1;
+
+alter table `todo`
+    add column `updated` datetime default current_timestamp on update current_timestamp after `created`,
+    add column `version` int not null default 1 after `updated`;
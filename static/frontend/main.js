@@ -65,7 +65,20 @@ const TodoComponent = (id, text, done) => {
     return todo
 }
 
+async function confirmSecondFactor() {
+    const code = prompt("Enter the code from your authenticator app or a recovery code")
+    const confirmed = await fetch(`/auth/2fa?code=${encodeURIComponent(code)}`, {
+        method: "POST"
+    })
+    return confirmed.ok
+}
+
 async function initializePage() {
+    if (new URLSearchParams(location.search).get("2fa") == "required") {
+        // an external sign in is waiting for the second factor
+        await confirmSecondFactor()
+        history.replaceState(null, "", "/")
+    }
     let res = await fetch("/api/todo")
     if (res.status == 401) {
         const username = prompt("You're not logged in. What's your username?")
@@ -136,6 +149,9 @@ async function loginUser(username, password) {
             Authorization: `Basic ${encoded}`,
         },
     })
+    if (res.status == 202) {
+        return confirmSecondFactor()
+    }
     return res.status < 400
 }
 
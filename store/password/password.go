@@ -0,0 +1,108 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hashes are stored in the PHC string format which carries algorithm,
+// parameters and salt along with the hash:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+//
+// Hashes created before this format was introduced are plain bcrypt hashes
+// of the user's name, the password and the global PW_SALT. They are still
+// accepted but should be replaced through Hash on the next successful login.
+
+type Params struct {
+	Memory  uint32 // in KiB
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// Parameters used for new hashes. Hashes with different parameters
+// are reported as needing a rehash.
+var DefaultParams = Params{
+	Memory:  64 * 1024,
+	Time:    3,
+	Threads: 2,
+	SaltLen: 16,
+	KeyLen:  32,
+}
+
+var ErrUnknownFormat = errors.New("unknown password hash format")
+
+var b64 = base64.RawStdEncoding
+
+// Hash the password with argon2id and a random salt.
+func Hash(password string) (string, error) {
+	p := DefaultParams
+	salt := make([]byte, p.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+// Compare the password against the encoded hash. Name and legacySalt are
+// only used for legacy bcrypt hashes. needsRehash reports that the password
+// was correct but the hash is outdated and should be replaced.
+func Verify(encoded, password, name, legacySalt string) (ok bool, needsRehash bool) {
+	if IsLegacy(encoded) {
+		// no error means password was correct
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(name+password+legacySalt))
+		return err == nil, err == nil
+	}
+
+	p, salt, key, err := decode(encoded)
+	if err != nil {
+		return false, false
+	}
+	other := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, false
+	}
+	return true, p != DefaultParams
+}
+
+// Reports whether the hash still mixes in name and global salt.
+func IsLegacy(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func decode(encoded string) (Params, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	// leading empty part, algorithm, version, params, salt, hash
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Params{}, nil, nil, ErrUnknownFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Params{}, nil, nil, ErrUnknownFormat
+	}
+	var p Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return Params{}, nil, nil, ErrUnknownFormat
+	}
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return Params{}, nil, nil, ErrUnknownFormat
+	}
+	key, err := b64.DecodeString(parts[5])
+	if err != nil {
+		return Params{}, nil, nil, ErrUnknownFormat
+	}
+	p.SaltLen, p.KeyLen = uint32(len(salt)), uint32(len(key))
+	return p, salt, key, nil
+}
@@ -0,0 +1,79 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashAndVerify(t *testing.T) {
+	encoded, err := Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=65536,t=3,p=2$") {
+		t.Errorf("unexpected hash format %s", encoded)
+	}
+	if other, _ := Hash("correct horse"); other == encoded {
+		t.Error("hashes of the same password are equal, salt is missing")
+	}
+
+	if ok, rehash := Verify(encoded, "correct horse", "", ""); !ok || rehash {
+		t.Errorf("correct password: got ok %t, rehash %t", ok, rehash)
+	}
+	if ok, rehash := Verify(encoded, "wrong horse", "", ""); ok || rehash {
+		t.Errorf("wrong password: got ok %t, rehash %t", ok, rehash)
+	}
+}
+
+func TestVerifyOutdatedParams(t *testing.T) {
+	defaults := DefaultParams
+	DefaultParams.Time = 1
+	encoded, err := Hash("secret")
+	DefaultParams = defaults
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, rehash := Verify(encoded, "secret", "", ""); !ok || !rehash {
+		t.Errorf("got ok %t, rehash %t, want a rehash", ok, rehash)
+	}
+}
+
+func TestVerifyLegacy(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("alice"+"secret"+"pepper"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsLegacy(string(legacy)) {
+		t.Fatalf("%s not recognized as legacy hash", legacy)
+	}
+	if ok, rehash := Verify(string(legacy), "secret", "alice", "pepper"); !ok || !rehash {
+		t.Errorf("correct password: got ok %t, rehash %t", ok, rehash)
+	}
+	if ok, _ := Verify(string(legacy), "secret", "bob", "pepper"); ok {
+		t.Error("accepted with another name")
+	}
+	if ok, _ := Verify(string(legacy), "secret", "alice", ""); ok {
+		t.Error("accepted without the global salt")
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	for _, encoded := range []string{
+		"",
+		"plain",
+		"$argon2i$v=19$m=65536,t=3,p=2$c2FsdA$a2V5",
+		"$argon2id$v=16$m=65536,t=3,p=2$c2FsdA$a2V5",
+		"$argon2id$v=19$m=x,t=3,p=2$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=2$!$a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=2$c2FsdA",
+	} {
+		if ok, rehash := Verify(encoded, "secret", "", ""); ok || rehash {
+			t.Errorf("%q: got ok %t, rehash %t", encoded, ok, rehash)
+		}
+		if _, _, _, err := decode(encoded); err != ErrUnknownFormat {
+			t.Errorf("%q: got %v, want ErrUnknownFormat", encoded, err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package stores
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const testDatabase = "check42_test"
+
+// Open a fresh database created from sql/initdb.sql. The tests need a MySQL
+// server, given as DSN without database in TEST_MYSQL_DSN, e.g.
+// 'root:root@tcp(localhost:3306)/', and are skipped without it.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("TEST_MYSQL_DSN not set")
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		t.Fatalf("invalid TEST_MYSQL_DSN: %v", err)
+	}
+	cfg.DBName = ""
+	cfg.ParseTime = true
+	cfg.MultiStatements = true
+
+	script, err := os.ReadFile("../../sql/initdb.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec("drop database if exists " + testDatabase)
+		db.Close()
+	})
+	// a single connection keeps the 'use' of the script in effect
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("drop database if exists " + testDatabase); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(strings.ReplaceAll(string(script), "`check42`", testDatabase)); err != nil {
+		t.Fatalf("initdb.sql: %v", err)
+	}
+	return db
+}
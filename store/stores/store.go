@@ -2,30 +2,81 @@ package stores
 
 import (
 	"check42/model"
+	"context"
 	"errors"
+	"time"
 )
 
 type UserStore interface {
-	GetUserByID(id int) (model.User, error)
-	GetUserByName(name string) (model.User, error)
-	CreateUser(model.CreateUser) error
+	GetUserByID(ctx context.Context, id int) (model.User, error)
+	GetUserByName(ctx context.Context, name string) (model.User, error)
+	GetUserByEmail(ctx context.Context, email string) (model.User, error)
+	CreateUser(ctx context.Context, u model.CreateUser) error
+	UpdateProfile(ctx context.Context, userID int64, name, email, password string) error
+	DeleteUser(ctx context.Context, userID int64) error
+
+	// Administration
+	GetAllUsers(ctx context.Context) ([]model.User, error)
+	SetDisabled(ctx context.Context, userID int64, disabled bool) error
+	ClearPassword(ctx context.Context, userID int64) error
+	// Void every session of the user, e.g. after their password was reset.
+	EndSessions(ctx context.Context, userID int64) error
+	GetUserStats(ctx context.Context) (model.UserStats, error)
+
+	// Users signing in through an external identity provider
+	GetUserByIdentity(ctx context.Context, provider, subject string) (model.User, error)
+	LinkIdentity(ctx context.Context, userID int64, provider, subject string) error
+	CreateExternalUser(ctx context.Context, name, email, provider, subject string) (model.User, error)
+
+	// Two-factor authentication
+	SetTOTPSecret(ctx context.Context, userID int64, secret string) error
+	EnableTOTP(ctx context.Context, userID int64, recoveryHashes []string) error
+	DisableTOTP(ctx context.Context, userID int64) error
+	UseRecoveryCode(ctx context.Context, userID int64, hash string) (bool, error)
+	// Record the time step of an accepted TOTP code. Reports false if a code
+	// of the same or a later step has been accepted before.
+	UseTOTPStep(ctx context.Context, userID, step int64) (bool, error)
+
+	// Email verification and password reset
+	CreateToken(ctx context.Context, userID int64, purpose, hash string, expires time.Time) error
+	ConsumeToken(ctx context.Context, purpose, hash string) (int64, error)
+	SetEmailVerified(ctx context.Context, userID int64) error
+	SetPassword(ctx context.Context, userID int64, password string) error
 }
 
+// Updates and deletes compare and swap: if the given version is not zero,
+// they only succeed while it matches the stored one and fail with ErrConflict
+// otherwise.
+// Categories other than 0 have to belong to the user, otherwise writing them
+// to a todo fails with ErrNoCategory.
 type TodoStore interface {
-	GetAllTodos(userID int64) ([]model.Todo, error)
-	UpdateTodo(todoID, userID int64, update model.Todo) error
-	GetTodo(todoID, userID int64) (model.Todo, error)
-	CreateTodo(t model.CreateTodo) (int64, error)
-	DeleteTodo(todoID, userID int64) error
-
-	CreateCategory(name string, userID int64) (int64, error)
-	GetAllCategories(userID int64) ([]model.TodoCategory, error)
-	UpdateCategory(name string, categoryID, userID int64) error
-	DeleteCategory(categoryID, userID int64) error
+	GetAllTodos(ctx context.Context, userID int64) ([]model.Todo, error)
+	UpdateTodo(ctx context.Context, todoID, userID int64, update model.Todo) error
+	GetTodo(ctx context.Context, todoID, userID int64) (model.Todo, error)
+	CreateTodo(ctx context.Context, t model.CreateTodo) (int64, error)
+	DeleteTodo(ctx context.Context, todoID, userID, version int64) error
+	// Read the todo, let fn change it and write text, done and category back
+	// in one transaction, so no concurrent update gets lost in between.
+	// An error returned by fn aborts the update and is passed on.
+	PatchTodo(ctx context.Context, todoID, userID int64, fn func(*model.Todo) error) (model.Todo, error)
+	// Move the todos into the category, 0 being none. Fails with ErrNotFound
+	// and moves nothing if one of them does not belong to the user.
+	MoveTodos(ctx context.Context, categoryID, userID int64, todoIDs []int64) error
+
+	CreateCategory(ctx context.Context, name string, userID int64) (int64, error)
+	GetAllCategories(ctx context.Context, userID int64) ([]model.TodoCategory, error)
+	UpdateCategory(ctx context.Context, name string, categoryID, userID, version int64) error
+	DeleteCategory(ctx context.Context, categoryID, userID int64) error
+	// Same as PatchTodo for the name of a category.
+	PatchCategory(ctx context.Context, categoryID, userID int64, fn func(*model.TodoCategory) error) (model.TodoCategory, error)
+
+	GetTodoStats(ctx context.Context) (model.TodoStats, error)
 }
 
 var (
 	ErrNotFound      = errors.New("item not found")
+	ErrConflict      = errors.New("item has been modified concurrently")
+	ErrNoCategory    = errors.New("category does not exist")
 	ErrUsernameTaken = errors.New("username is taken")
 	ErrEmailTaken    = errors.New("email is taken")
 )
@@ -2,7 +2,10 @@ package stores
 
 import (
 	"check42/model"
+	"context"
 	"database/sql"
+	"slices"
+	"strings"
 )
 
 type TodoDB struct {
@@ -13,14 +16,14 @@ func NewMySQLTodoStore(db *sql.DB) *TodoDB {
 	return &TodoDB{db}
 }
 
-func (store *TodoDB) CreateTodo(t model.CreateTodo) (int64, error) {
+func (store *TodoDB) CreateTodo(ctx context.Context, t model.CreateTodo) (int64, error) {
 	catID := sql.NullInt64{Int64: t.Category.ID, Valid: t.Category.ID != 0}
 	q := `
 		insert into todo
 		(owner, text, done, category) values 
 			(?, ?, ?, ?)
 	`
-	result, err := store.db.Exec(q, t.Owner, t.Text, t.Done, catID)
+	result, err := store.db.ExecContext(ctx, q, t.Owner, t.Text, t.Done, catID)
 	if err != nil {
 		return 0, err
 	}
@@ -32,17 +35,22 @@ func (store *TodoDB) CreateTodo(t model.CreateTodo) (int64, error) {
 	return id, nil
 }
 
-func (store *TodoDB) DeleteTodo(todoID, userID int64) error {
-	_, err := store.db.Query(`
+func (store *TodoDB) DeleteTodo(ctx context.Context, todoID, userID, version int64) error {
+	result, err := store.db.ExecContext(ctx, `
 		delete from todo
 		where id = ?
-		and owner = ?`, todoID, userID)
-	return err
+			and owner = ?
+			and (? = 0 or version = ?)
+	`, todoID, userID, version, version)
+	if err != nil {
+		return err
+	}
+	return store.expectSwapped(ctx, result, "todo", todoID, userID)
 }
 
-func (store *TodoDB) GetAllTodos(userID int64) ([]model.Todo, error) {
-	rows, err := store.db.Query(`
-		select t.id, t.owner, text, done, created, cat.id, cat.name
+func (store *TodoDB) GetAllTodos(ctx context.Context, userID int64) ([]model.Todo, error) {
+	rows, err := store.db.QueryContext(ctx, `
+		select t.id, t.owner, t.text, t.done, t.created, t.updated, t.version, cat.id, cat.name, cat.version
 		from todo as t
 			left join todo_category as cat
 			on t.category = cat.id
@@ -55,6 +63,7 @@ func (store *TodoDB) GetAllTodos(userID int64) ([]model.Todo, error) {
 	var t model.Todo
 	var categoryID sql.NullInt64
 	var categoryName sql.NullString
+	var categoryVersion sql.NullInt64
 
 	for rows.Next() {
 
@@ -64,8 +73,11 @@ func (store *TodoDB) GetAllTodos(userID int64) ([]model.Todo, error) {
 			&t.Text,
 			&t.Done,
 			&t.Created,
+			&t.Updated,
+			&t.Version,
 			&categoryID,
 			&categoryName,
+			&categoryVersion,
 		)
 
 		if err != nil {
@@ -74,15 +86,16 @@ func (store *TodoDB) GetAllTodos(userID int64) ([]model.Todo, error) {
 
 		t.Category.ID = categoryID.Int64
 		t.Category.Name = categoryName.String
+		t.Category.Version = categoryVersion.Int64
 
 		todos = append(todos, t)
 	}
 	return todos, nil
 }
 
-func (store *TodoDB) GetAllTodosByCategory(categoryID, userID int64) ([]model.Todo, error) {
-	rows, err := store.db.Query(`
-		select t.id, t.owner, text, done, created, cat.id, cat.name
+func (store *TodoDB) GetAllTodosByCategory(ctx context.Context, categoryID, userID int64) ([]model.Todo, error) {
+	rows, err := store.db.QueryContext(ctx, `
+		select t.id, t.owner, t.text, t.done, t.created, t.updated, t.version, cat.id, cat.name, cat.version
 		from todo as t
 			left join todo_category as cat
 			on t.category = cat.id
@@ -96,6 +109,7 @@ func (store *TodoDB) GetAllTodosByCategory(categoryID, userID int64) ([]model.To
 	var t model.Todo
 	var catID sql.NullInt64
 	var catName sql.NullString
+	var catVersion sql.NullInt64
 
 	for rows.Next() {
 
@@ -105,8 +119,11 @@ func (store *TodoDB) GetAllTodosByCategory(categoryID, userID int64) ([]model.To
 			&t.Text,
 			&t.Done,
 			&t.Created,
+			&t.Updated,
+			&t.Version,
 			&catID,
 			&catName,
+			&catVersion,
 		)
 
 		if err != nil {
@@ -115,24 +132,165 @@ func (store *TodoDB) GetAllTodosByCategory(categoryID, userID int64) ([]model.To
 
 		t.Category.ID = catID.Int64
 		t.Category.Name = catName.String
+		t.Category.Version = catVersion.Int64
 
 		todos = append(todos, t)
 	}
 	return todos, nil
 }
 
-func (store *TodoDB) GetTodo(todoID, userID int64) (model.Todo, error) {
-	row := store.db.QueryRow(`
-		select t.id, t.owner, text, done, created, cat.id, cat.name
+func (store *TodoDB) GetTodo(ctx context.Context, todoID, userID int64) (model.Todo, error) {
+	return scanTodo(store.db.QueryRowContext(ctx, `
+		select t.id, t.owner, t.text, t.done, t.created, t.updated, t.version, cat.id, cat.name, cat.version
+		from todo as t
+			left join todo_category as cat
+			on t.category = cat.id
+		where t.id = ?
+			and t.owner = ?`, todoID, userID))
+}
+
+func (store *TodoDB) UpdateTodo(ctx context.Context, todoID, userID int64, t model.Todo) error {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if t.Category.ID != 0 {
+		if err := categoryExists(ctx, tx, t.Category.ID, userID); err != nil {
+			return err
+		}
+	}
+	catID := sql.NullInt64{Int64: t.Category.ID, Valid: t.Category.ID != 0}
+	result, err := tx.ExecContext(ctx, `
+		update todo
+		set text = ?, done = ?, category = ?, version = version + 1
+		where id = ?
+			and owner = ?
+			and (? = 0 or version = ?)
+	`, t.Text, t.Done, catID, todoID, userID, t.Version, t.Version)
+	if err != nil {
+		return err
+	}
+	if err := store.expectSwapped(ctx, result, "todo", todoID, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *TodoDB) MoveTodos(ctx context.Context, categoryID, userID int64, todoIDs []int64) error {
+	ids := slices.Clone(todoIDs)
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	if len(ids) == 0 {
+		return nil
+	}
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if categoryID != 0 {
+		if err := categoryExists(ctx, tx, categoryID, userID); err != nil {
+			return err
+		}
+	}
+	args := []any{sql.NullInt64{Int64: categoryID, Valid: categoryID != 0}, userID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	result, err := tx.ExecContext(ctx, `
+		update todo
+		set category = ?, version = version + 1
+		where owner = ?
+			and id in (?`+strings.Repeat(", ?", len(ids)-1)+`)
+	`, args...)
+	if err != nil {
+		return err
+	}
+	// all or nothing, moving only some of them would surprise the caller
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != int64(len(ids)) {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+func (store *TodoDB) PatchTodo(ctx context.Context, todoID, userID int64, fn func(*model.Todo) error) (model.Todo, error) {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.Todo{}, err
+	}
+	defer tx.Rollback()
+
+	// the lock keeps concurrent updates out until the transaction ends
+	t, err := scanTodo(tx.QueryRowContext(ctx, `
+		select t.id, t.owner, t.text, t.done, t.created, t.updated, t.version, cat.id, cat.name, cat.version
 		from todo as t
 			left join todo_category as cat
 			on t.category = cat.id
 		where t.id = ?
-			and t.owner = ?`, todoID, userID)
+			and t.owner = ?
+		for update`, todoID, userID))
+	if err != nil {
+		return model.Todo{}, err
+	}
+	previousCategory := t.Category.ID
+	if err := fn(&t); err != nil {
+		return model.Todo{}, err
+	}
+	if t.Category.ID != previousCategory && t.Category.ID != 0 {
+		if err := categoryExists(ctx, tx, t.Category.ID, userID); err != nil {
+			return model.Todo{}, err
+		}
+	}
 
+	catID := sql.NullInt64{Int64: t.Category.ID, Valid: t.Category.ID != 0}
+	_, err = tx.ExecContext(ctx, `
+		update todo
+		set text = ?, done = ?, category = ?, version = version + 1
+		where id = ?
+	`, t.Text, t.Done, catID, todoID)
+	if err != nil {
+		return model.Todo{}, err
+	}
+	t, err = scanTodo(tx.QueryRowContext(ctx, `
+		select t.id, t.owner, t.text, t.done, t.created, t.updated, t.version, cat.id, cat.name, cat.version
+		from todo as t
+			left join todo_category as cat
+			on t.category = cat.id
+		where t.id = ?`, todoID))
+	if err != nil {
+		return model.Todo{}, err
+	}
+	return t, tx.Commit()
+}
+
+// Fails with ErrNoCategory unless the category exists and belongs to the user.
+func categoryExists(ctx context.Context, tx *sql.Tx, categoryID, userID int64) error {
+	var exists bool
+	err := tx.QueryRowContext(ctx, `
+		select exists (select 1 from todo_category where id = ? and owner = ?)
+	`, categoryID, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoCategory
+	}
+	return nil
+}
+
+func scanTodo(row *sql.Row) (model.Todo, error) {
 	var t model.Todo
 	var catID sql.NullInt64
 	var catName sql.NullString
+	var catVersion sql.NullInt64
 
 	err := row.Scan(
 		&t.ID,
@@ -140,13 +298,12 @@ func (store *TodoDB) GetTodo(todoID, userID int64) (model.Todo, error) {
 		&t.Text,
 		&t.Done,
 		&t.Created,
+		&t.Updated,
+		&t.Version,
 		&catID,
 		&catName,
+		&catVersion,
 	)
-
-	t.Category.ID = catID.Int64
-	t.Category.Name = catName.String
-
 	if err == sql.ErrNoRows {
 		return model.Todo{}, ErrNotFound
 	}
@@ -154,21 +311,14 @@ func (store *TodoDB) GetTodo(todoID, userID int64) (model.Todo, error) {
 		return model.Todo{}, err
 	}
 
+	t.Category.ID = catID.Int64
+	t.Category.Name = catName.String
+	t.Category.Version = catVersion.Int64
 	return t, nil
 }
 
-func (store *TodoDB) UpdateTodo(todoID, userID int64, t model.Todo) error {
-	_, err := store.db.Exec(`
-		update todo
-		set text = ?, done = ?
-		where id = ?
-			and owner = ?
-	`, t.Text, t.Done, todoID, userID)
-	return err
-}
-
-func (store *TodoDB) CreateCategory(name string, userID int64) (int64, error) {
-	result, err := store.db.Exec(`
+func (store *TodoDB) CreateCategory(ctx context.Context, name string, userID int64) (int64, error) {
+	result, err := store.db.ExecContext(ctx, `
 		insert into todo_category
 		(name, owner) values
 			(?, ?)
@@ -184,9 +334,9 @@ func (store *TodoDB) CreateCategory(name string, userID int64) (int64, error) {
 	return id, nil
 }
 
-func (store *TodoDB) GetAllCategories(userID int64) ([]model.TodoCategory, error) {
-	rows, err := store.db.Query(`
-		select id, name
+func (store *TodoDB) GetAllCategories(ctx context.Context, userID int64) ([]model.TodoCategory, error) {
+	rows, err := store.db.QueryContext(ctx, `
+		select id, name, version
 		from todo_category
 		where owner = ?
 	`, userID)
@@ -199,6 +349,7 @@ func (store *TodoDB) GetAllCategories(userID int64) ([]model.TodoCategory, error
 		err := rows.Scan(
 			&cat.ID,
 			&cat.Name,
+			&cat.Version,
 		)
 		if err != nil {
 			return nil, err
@@ -208,21 +359,94 @@ func (store *TodoDB) GetAllCategories(userID int64) ([]model.TodoCategory, error
 	return cats, nil
 }
 
-func (store *TodoDB) UpdateCategory(name string, categoryID, userID int64) error {
-	_, err := store.db.Exec(`
-		update todo_category set
-		set name = ?
+func (store *TodoDB) UpdateCategory(ctx context.Context, name string, categoryID, userID, version int64) error {
+	result, err := store.db.ExecContext(ctx, `
+		update todo_category
+		set name = ?, version = version + 1
 		where id = ?
 			and owner = ?
-	`, name, categoryID, userID)
-	return err
+			and (? = 0 or version = ?)
+	`, name, categoryID, userID, version, version)
+	if err != nil {
+		return err
+	}
+	return store.expectSwapped(ctx, result, "todo_category", categoryID, userID)
 }
 
-func (store *TodoDB) DeleteCategory(categoryID, userID int64) error {
-	_, err := store.db.Exec(`
+func (store *TodoDB) PatchCategory(ctx context.Context, categoryID, userID int64, fn func(*model.TodoCategory) error) (model.TodoCategory, error) {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.TodoCategory{}, err
+	}
+	defer tx.Rollback()
+
+	var cat model.TodoCategory
+	err = tx.QueryRowContext(ctx, `
+		select id, name, version
+		from todo_category
+		where id = ?
+			and owner = ?
+		for update
+	`, categoryID, userID).Scan(&cat.ID, &cat.Name, &cat.Version)
+	if err == sql.ErrNoRows {
+		return model.TodoCategory{}, ErrNotFound
+	}
+	if err != nil {
+		return model.TodoCategory{}, err
+	}
+	if err := fn(&cat); err != nil {
+		return model.TodoCategory{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		update todo_category
+		set name = ?, version = version + 1
+		where id = ?
+	`, cat.Name, categoryID)
+	if err != nil {
+		return model.TodoCategory{}, err
+	}
+	cat.Version++
+	return cat, tx.Commit()
+}
+
+// Tell apart why a compare and swap update did not change a row: ErrNotFound
+// if the row does not exist, ErrConflict if its version has moved on.
+// The version always changes, so a matched row is always reported as affected.
+func (store *TodoDB) expectSwapped(ctx context.Context, result sql.Result, table string, id, userID int64) error {
+	n, err := result.RowsAffected()
+	if err != nil || n != 0 {
+		return err
+	}
+	var exists bool
+	err = store.db.QueryRowContext(ctx, `
+		select exists (select 1 from `+table+` where id = ? and owner = ?)
+	`, id, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return ErrConflict
+}
+
+func (store *TodoDB) DeleteCategory(ctx context.Context, categoryID, userID int64) error {
+	_, err := store.db.ExecContext(ctx, `
 		delete from todo_category
 		where id = ?
 			and owner = ?
 	`, categoryID, userID)
 	return err
 }
+
+func (store *TodoDB) GetTodoStats(ctx context.Context) (model.TodoStats, error) {
+	var stats model.TodoStats
+	err := store.db.QueryRowContext(ctx, `
+		select
+			(select count(*) from todo),
+			(select count(*) from todo where done),
+			(select count(*) from todo_category)
+	`).Scan(&stats.Todos, &stats.DoneTodos, &stats.Categories)
+	return stats, err
+}
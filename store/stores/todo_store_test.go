@@ -0,0 +1,109 @@
+package stores
+
+import (
+	"check42/model"
+	"context"
+	"testing"
+)
+
+// The seed data of initdb.sql belongs to user 1 and has todos with and
+// without category.
+const seedOwner = 1
+
+func TestTodoQueries(t *testing.T) {
+	store := NewMySQLTodoStore(openTestDB(t))
+	ctx := context.Background()
+
+	todos, err := store.GetAllTodos(ctx, seedOwner)
+	if err != nil {
+		t.Fatalf("GetAllTodos: %v", err)
+	}
+	if len(todos) == 0 {
+		t.Fatal("GetAllTodos: no seeded todos")
+	}
+	if _, err := store.GetAllTodosByCategory(ctx, 1, seedOwner); err != nil {
+		t.Fatalf("GetAllTodosByCategory: %v", err)
+	}
+
+	todo, err := store.GetTodo(ctx, todos[0].ID, seedOwner)
+	if err != nil {
+		t.Fatalf("GetTodo: %v", err)
+	}
+	if todo.Version != 1 || todo.Category.ID == 0 || todo.Category.Version != 1 {
+		t.Errorf("GetTodo: got version %d and category %+v", todo.Version, todo.Category)
+	}
+	if _, err := store.GetTodo(ctx, todo.ID, seedOwner+1); err != ErrNotFound {
+		t.Errorf("GetTodo of another user: got %v, want ErrNotFound", err)
+	}
+
+	todo.Text = "changed"
+	if err := store.UpdateTodo(ctx, todo.ID, seedOwner, todo); err != nil {
+		t.Fatalf("UpdateTodo: %v", err)
+	}
+	if err := store.UpdateTodo(ctx, todo.ID, seedOwner, todo); err != ErrConflict {
+		t.Errorf("UpdateTodo with outdated version: got %v, want ErrConflict", err)
+	}
+
+	patched, err := store.PatchTodo(ctx, todo.ID, seedOwner, func(t *model.Todo) error {
+		t.Done = true
+		t.Category = model.TodoCategory{}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("PatchTodo: %v", err)
+	}
+	if !patched.Done || patched.Category.ID != 0 || patched.Version != 3 {
+		t.Errorf("PatchTodo: got %+v", patched)
+	}
+	_, err = store.PatchTodo(ctx, todo.ID, seedOwner, func(t *model.Todo) error {
+		t.Category.ID = 1 << 30
+		return nil
+	})
+	if err != ErrNoCategory {
+		t.Errorf("PatchTodo to a missing category: got %v, want ErrNoCategory", err)
+	}
+
+	if err := store.MoveTodos(ctx, 2, seedOwner, []int64{todos[0].ID, todos[1].ID}); err != nil {
+		t.Fatalf("MoveTodos: %v", err)
+	}
+	if err := store.MoveTodos(ctx, 2, seedOwner, []int64{todos[0].ID, 1 << 30}); err != ErrNotFound {
+		t.Errorf("MoveTodos with a missing todo: got %v, want ErrNotFound", err)
+	}
+
+	if err := store.DeleteTodo(ctx, todo.ID, seedOwner, 1); err != ErrConflict {
+		t.Errorf("DeleteTodo with outdated version: got %v, want ErrConflict", err)
+	}
+	if err := store.DeleteTodo(ctx, todo.ID, seedOwner, 0); err != nil {
+		t.Fatalf("DeleteTodo: %v", err)
+	}
+	if err := store.DeleteTodo(ctx, todo.ID, seedOwner, 0); err != ErrNotFound {
+		t.Errorf("DeleteTodo twice: got %v, want ErrNotFound", err)
+	}
+
+	if _, err := store.GetAllCategories(ctx, seedOwner); err != nil {
+		t.Fatalf("GetAllCategories: %v", err)
+	}
+	before, err := store.GetAllTodosByCategory(ctx, 1, seedOwner)
+	if err != nil {
+		t.Fatalf("GetAllTodosByCategory: %v", err)
+	}
+	cat, err := store.PatchCategory(ctx, 1, seedOwner, func(c *model.TodoCategory) error {
+		c.Name = "Elsewhere"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("PatchCategory: %v", err)
+	}
+	after, err := store.GetAllTodosByCategory(ctx, 1, seedOwner)
+	if err != nil {
+		t.Fatalf("GetAllTodosByCategory: %v", err)
+	}
+	// renaming the category changes the representation of its todos
+	if model.TodoList(before).Validators().ETag == model.TodoList(after).Validators().ETag ||
+		before[0].Validators().ETag == after[0].Validators().ETag {
+		t.Error("ETags unchanged after renaming the category")
+	}
+	if err := store.UpdateCategory(ctx, "Again", 1, seedOwner, cat.Version-1); err != ErrConflict {
+		t.Errorf("UpdateCategory with outdated version: got %v, want ErrConflict", err)
+	}
+}
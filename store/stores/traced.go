@@ -0,0 +1,251 @@
+package stores
+
+import (
+	"check42/model"
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("check42/store/stores")
+
+// Start a client span for a store call.
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "mysql")),
+	)
+}
+
+// End the span, marking it as failed for errors other than ErrNotFound,
+// ErrConflict and ErrNoCategory which are expected outcomes.
+// Returns err for convenience.
+func endSpan(span trace.Span, err error) error {
+	if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrConflict) && !errors.Is(err, ErrNoCategory) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	return err
+}
+
+// Wraps a UserStore to record a span for every call.
+type tracedUserStore struct {
+	next UserStore
+}
+
+func TraceUserStore(next UserStore) UserStore {
+	return tracedUserStore{next}
+}
+
+func (s tracedUserStore) GetUserByID(ctx context.Context, id int) (model.User, error) {
+	ctx, span := startSpan(ctx, "UserStore.GetUserByID")
+	result, err := s.next.GetUserByID(ctx, id)
+	return result, endSpan(span, err)
+}
+
+func (s tracedUserStore) GetUserByName(ctx context.Context, name string) (model.User, error) {
+	ctx, span := startSpan(ctx, "UserStore.GetUserByName")
+	result, err := s.next.GetUserByName(ctx, name)
+	return result, endSpan(span, err)
+}
+
+func (s tracedUserStore) GetUserByEmail(ctx context.Context, email string) (model.User, error) {
+	ctx, span := startSpan(ctx, "UserStore.GetUserByEmail")
+	result, err := s.next.GetUserByEmail(ctx, email)
+	return result, endSpan(span, err)
+}
+
+func (s tracedUserStore) CreateUser(ctx context.Context, u model.CreateUser) error {
+	ctx, span := startSpan(ctx, "UserStore.CreateUser")
+	return endSpan(span, s.next.CreateUser(ctx, u))
+}
+
+func (s tracedUserStore) UpdateProfile(ctx context.Context, userID int64, name, email, password string) error {
+	ctx, span := startSpan(ctx, "UserStore.UpdateProfile")
+	return endSpan(span, s.next.UpdateProfile(ctx, userID, name, email, password))
+}
+
+func (s tracedUserStore) DeleteUser(ctx context.Context, userID int64) error {
+	ctx, span := startSpan(ctx, "UserStore.DeleteUser")
+	return endSpan(span, s.next.DeleteUser(ctx, userID))
+}
+
+func (s tracedUserStore) GetAllUsers(ctx context.Context) ([]model.User, error) {
+	ctx, span := startSpan(ctx, "UserStore.GetAllUsers")
+	result, err := s.next.GetAllUsers(ctx)
+	return result, endSpan(span, err)
+}
+
+func (s tracedUserStore) SetDisabled(ctx context.Context, userID int64, disabled bool) error {
+	ctx, span := startSpan(ctx, "UserStore.SetDisabled")
+	return endSpan(span, s.next.SetDisabled(ctx, userID, disabled))
+}
+
+func (s tracedUserStore) ClearPassword(ctx context.Context, userID int64) error {
+	ctx, span := startSpan(ctx, "UserStore.ClearPassword")
+	return endSpan(span, s.next.ClearPassword(ctx, userID))
+}
+
+func (s tracedUserStore) EndSessions(ctx context.Context, userID int64) error {
+	ctx, span := startSpan(ctx, "UserStore.EndSessions")
+	return endSpan(span, s.next.EndSessions(ctx, userID))
+}
+
+func (s tracedUserStore) GetUserStats(ctx context.Context) (model.UserStats, error) {
+	ctx, span := startSpan(ctx, "UserStore.GetUserStats")
+	result, err := s.next.GetUserStats(ctx)
+	return result, endSpan(span, err)
+}
+
+func (s tracedUserStore) GetUserByIdentity(ctx context.Context, provider, subject string) (model.User, error) {
+	ctx, span := startSpan(ctx, "UserStore.GetUserByIdentity")
+	result, err := s.next.GetUserByIdentity(ctx, provider, subject)
+	return result, endSpan(span, err)
+}
+
+func (s tracedUserStore) LinkIdentity(ctx context.Context, userID int64, provider, subject string) error {
+	ctx, span := startSpan(ctx, "UserStore.LinkIdentity")
+	return endSpan(span, s.next.LinkIdentity(ctx, userID, provider, subject))
+}
+
+func (s tracedUserStore) CreateExternalUser(ctx context.Context, name, email, provider, subject string) (model.User, error) {
+	ctx, span := startSpan(ctx, "UserStore.CreateExternalUser")
+	result, err := s.next.CreateExternalUser(ctx, name, email, provider, subject)
+	return result, endSpan(span, err)
+}
+
+func (s tracedUserStore) SetTOTPSecret(ctx context.Context, userID int64, secret string) error {
+	ctx, span := startSpan(ctx, "UserStore.SetTOTPSecret")
+	return endSpan(span, s.next.SetTOTPSecret(ctx, userID, secret))
+}
+
+func (s tracedUserStore) EnableTOTP(ctx context.Context, userID int64, recoveryHashes []string) error {
+	ctx, span := startSpan(ctx, "UserStore.EnableTOTP")
+	return endSpan(span, s.next.EnableTOTP(ctx, userID, recoveryHashes))
+}
+
+func (s tracedUserStore) DisableTOTP(ctx context.Context, userID int64) error {
+	ctx, span := startSpan(ctx, "UserStore.DisableTOTP")
+	return endSpan(span, s.next.DisableTOTP(ctx, userID))
+}
+
+func (s tracedUserStore) UseRecoveryCode(ctx context.Context, userID int64, hash string) (bool, error) {
+	ctx, span := startSpan(ctx, "UserStore.UseRecoveryCode")
+	result, err := s.next.UseRecoveryCode(ctx, userID, hash)
+	return result, endSpan(span, err)
+}
+
+func (s tracedUserStore) UseTOTPStep(ctx context.Context, userID, step int64) (bool, error) {
+	ctx, span := startSpan(ctx, "UserStore.UseTOTPStep")
+	result, err := s.next.UseTOTPStep(ctx, userID, step)
+	return result, endSpan(span, err)
+}
+
+func (s tracedUserStore) CreateToken(ctx context.Context, userID int64, purpose, hash string, expires time.Time) error {
+	ctx, span := startSpan(ctx, "UserStore.CreateToken")
+	return endSpan(span, s.next.CreateToken(ctx, userID, purpose, hash, expires))
+}
+
+func (s tracedUserStore) ConsumeToken(ctx context.Context, purpose, hash string) (int64, error) {
+	ctx, span := startSpan(ctx, "UserStore.ConsumeToken")
+	result, err := s.next.ConsumeToken(ctx, purpose, hash)
+	return result, endSpan(span, err)
+}
+
+func (s tracedUserStore) SetEmailVerified(ctx context.Context, userID int64) error {
+	ctx, span := startSpan(ctx, "UserStore.SetEmailVerified")
+	return endSpan(span, s.next.SetEmailVerified(ctx, userID))
+}
+
+func (s tracedUserStore) SetPassword(ctx context.Context, userID int64, password string) error {
+	ctx, span := startSpan(ctx, "UserStore.SetPassword")
+	return endSpan(span, s.next.SetPassword(ctx, userID, password))
+}
+
+// Wraps a TodoStore to record a span for every call.
+type tracedTodoStore struct {
+	next TodoStore
+}
+
+func TraceTodoStore(next TodoStore) TodoStore {
+	return tracedTodoStore{next}
+}
+
+func (s tracedTodoStore) GetAllTodos(ctx context.Context, userID int64) ([]model.Todo, error) {
+	ctx, span := startSpan(ctx, "TodoStore.GetAllTodos")
+	result, err := s.next.GetAllTodos(ctx, userID)
+	return result, endSpan(span, err)
+}
+
+func (s tracedTodoStore) UpdateTodo(ctx context.Context, todoID, userID int64, update model.Todo) error {
+	ctx, span := startSpan(ctx, "TodoStore.UpdateTodo")
+	return endSpan(span, s.next.UpdateTodo(ctx, todoID, userID, update))
+}
+
+func (s tracedTodoStore) GetTodo(ctx context.Context, todoID, userID int64) (model.Todo, error) {
+	ctx, span := startSpan(ctx, "TodoStore.GetTodo")
+	result, err := s.next.GetTodo(ctx, todoID, userID)
+	return result, endSpan(span, err)
+}
+
+func (s tracedTodoStore) CreateTodo(ctx context.Context, t model.CreateTodo) (int64, error) {
+	ctx, span := startSpan(ctx, "TodoStore.CreateTodo")
+	result, err := s.next.CreateTodo(ctx, t)
+	return result, endSpan(span, err)
+}
+
+func (s tracedTodoStore) DeleteTodo(ctx context.Context, todoID, userID, version int64) error {
+	ctx, span := startSpan(ctx, "TodoStore.DeleteTodo")
+	return endSpan(span, s.next.DeleteTodo(ctx, todoID, userID, version))
+}
+
+func (s tracedTodoStore) PatchTodo(ctx context.Context, todoID, userID int64, fn func(*model.Todo) error) (model.Todo, error) {
+	ctx, span := startSpan(ctx, "TodoStore.PatchTodo")
+	result, err := s.next.PatchTodo(ctx, todoID, userID, fn)
+	return result, endSpan(span, err)
+}
+
+func (s tracedTodoStore) MoveTodos(ctx context.Context, categoryID, userID int64, todoIDs []int64) error {
+	ctx, span := startSpan(ctx, "TodoStore.MoveTodos")
+	return endSpan(span, s.next.MoveTodos(ctx, categoryID, userID, todoIDs))
+}
+
+func (s tracedTodoStore) CreateCategory(ctx context.Context, name string, userID int64) (int64, error) {
+	ctx, span := startSpan(ctx, "TodoStore.CreateCategory")
+	result, err := s.next.CreateCategory(ctx, name, userID)
+	return result, endSpan(span, err)
+}
+
+func (s tracedTodoStore) GetAllCategories(ctx context.Context, userID int64) ([]model.TodoCategory, error) {
+	ctx, span := startSpan(ctx, "TodoStore.GetAllCategories")
+	result, err := s.next.GetAllCategories(ctx, userID)
+	return result, endSpan(span, err)
+}
+
+func (s tracedTodoStore) UpdateCategory(ctx context.Context, name string, categoryID, userID, version int64) error {
+	ctx, span := startSpan(ctx, "TodoStore.UpdateCategory")
+	return endSpan(span, s.next.UpdateCategory(ctx, name, categoryID, userID, version))
+}
+
+func (s tracedTodoStore) DeleteCategory(ctx context.Context, categoryID, userID int64) error {
+	ctx, span := startSpan(ctx, "TodoStore.DeleteCategory")
+	return endSpan(span, s.next.DeleteCategory(ctx, categoryID, userID))
+}
+
+func (s tracedTodoStore) PatchCategory(ctx context.Context, categoryID, userID int64, fn func(*model.TodoCategory) error) (model.TodoCategory, error) {
+	ctx, span := startSpan(ctx, "TodoStore.PatchCategory")
+	result, err := s.next.PatchCategory(ctx, categoryID, userID, fn)
+	return result, endSpan(span, err)
+}
+
+func (s tracedTodoStore) GetTodoStats(ctx context.Context) (model.TodoStats, error) {
+	ctx, span := startSpan(ctx, "TodoStore.GetTodoStats")
+	result, err := s.next.GetTodoStats(ctx)
+	return result, endSpan(span, err)
+}
@@ -2,12 +2,12 @@ package stores
 
 import (
 	"check42/model"
+	"check42/store/password"
+	"context"
 	"database/sql"
 	"errors"
-	"os"
 	"strings"
-
-	"golang.org/x/crypto/bcrypt"
+	"time"
 )
 
 type UserDB struct {
@@ -18,35 +18,42 @@ func NewMySQLUserStore(db *sql.DB) UserDB {
 	return UserDB{db}
 }
 
-func (store UserDB) GetUserByID(id int) (model.User, error) {
-	row := store.db.QueryRow(`select * from user where id = ?`, id)
-	var u model.User
-	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.PasswordHash, &u.Created)
-	if err != nil {
-		return model.User{}, ErrNotFound
-	}
-	return u, nil
+const userColumns = `user.id, user.name, user.email, user.password_hash, user.created, user.email_verified, user.role, user.disabled, user.totp_secret, user.totp_enabled, user.session_version`
+
+// Shared by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
 }
 
-func (store UserDB) GetUserByName(name string) (model.User, error) {
-	row := store.db.QueryRow(`select * from user where name = ?`, name)
+func scanUser(row scanner) (model.User, error) {
 	var u model.User
-	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.PasswordHash, &u.Created)
+	var secret sql.NullString
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.PasswordHash, &u.Created, &u.EmailVerified, &u.Role, &u.Disabled, &secret, &u.TOTPEnabled, &u.SessionVersion)
 	if err != nil {
 		return model.User{}, ErrNotFound
 	}
+	u.TOTPSecret = secret.String
 	return u, nil
 }
 
-func (store UserDB) CreateUser(u model.CreateUser) error {
-	salt := os.Getenv("PW_SALT")
-	hash, err := bcrypt.GenerateFromPassword([]byte(u.Name+u.Password+salt), bcrypt.DefaultCost)
+func (store UserDB) GetUserByID(ctx context.Context, id int) (model.User, error) {
+	row := store.db.QueryRowContext(ctx, `select `+userColumns+` from user where id = ?`, id)
+	return scanUser(row)
+}
+
+func (store UserDB) GetUserByName(ctx context.Context, name string) (model.User, error) {
+	row := store.db.QueryRowContext(ctx, `select `+userColumns+` from user where name = ?`, name)
+	return scanUser(row)
+}
+
+func (store UserDB) CreateUser(ctx context.Context, u model.CreateUser) error {
+	hash, err := password.Hash(u.Password)
 	if err != nil {
 		return err
 	}
 
 	q := `insert into user (name, email, password_hash) values (?, ?, ?)`
-	_, err = store.db.Exec(q, u.Name, u.Email, hash)
+	_, err = store.db.ExecContext(ctx, q, u.Name, u.Email, hash)
 
 	if err != nil {
 		msg := err.Error()
@@ -63,3 +70,373 @@ func (store UserDB) CreateUser(u model.CreateUser) error {
 
 	return nil
 }
+
+// Translate unique key violations on the user table into the matching store errors.
+// Returns nil for any other error.
+func duplicateUserErr(err error) error {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "Duplicate entry") && strings.Contains(msg, "user.name"):
+		return ErrUsernameTaken
+	case strings.Contains(msg, "Duplicate entry") && strings.Contains(msg, "user.email"):
+		return ErrEmailTaken
+	}
+	return nil
+}
+
+func (store UserDB) GetUserByEmail(ctx context.Context, email string) (model.User, error) {
+	row := store.db.QueryRowContext(ctx, `select `+userColumns+` from user where email = ?`, email)
+	return scanUser(row)
+}
+
+func (store UserDB) GetUserByIdentity(ctx context.Context, provider, subject string) (model.User, error) {
+	row := store.db.QueryRowContext(ctx, `
+		select `+userColumns+`
+		from user
+			join user_identity as i
+			on i.user = user.id
+		where i.provider = ?
+			and i.subject = ?`, provider, subject)
+	return scanUser(row)
+}
+
+func (store UserDB) LinkIdentity(ctx context.Context, userID int64, provider, subject string) error {
+	_, err := store.db.ExecContext(ctx, `
+		insert into user_identity
+		(provider, subject, user) values
+			(?, ?, ?)
+	`, provider, subject, userID)
+	return err
+}
+
+// Create a user without a password that can only sign in through the given provider.
+// User and identity are inserted in the same transaction.
+func (store UserDB) CreateExternalUser(ctx context.Context, name, email, provider, subject string) (model.User, error) {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.User{}, err
+	}
+	defer tx.Rollback()
+
+	// an empty hash never matches any password
+	result, err := tx.ExecContext(ctx, `
+		insert into user
+		(name, email, password_hash, email_verified) values
+			(?, ?, '', 1)
+	`, name, email)
+	if err != nil {
+		if dup := duplicateUserErr(err); dup != nil {
+			return model.User{}, dup
+		}
+		return model.User{}, errors.New("error creating new user")
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return model.User{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		insert into user_identity
+		(provider, subject, user) values
+			(?, ?, ?)
+	`, provider, subject, id)
+	if err != nil {
+		return model.User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return model.User{}, err
+	}
+
+	return model.User{ID: id, Name: name, Email: email, EmailVerified: true, Role: model.RoleUser}, nil
+}
+
+// Store a new secret that is not yet used for logins until it has been confirmed.
+func (store UserDB) SetTOTPSecret(ctx context.Context, userID int64, secret string) error {
+	_, err := store.db.ExecContext(ctx, `
+		update user
+		set totp_secret = ?, totp_enabled = 0
+		where id = ?
+	`, secret, userID)
+	return err
+}
+
+// Require the second factor on login and replace all recovery codes with the given hashes.
+func (store UserDB) EnableTOTP(ctx context.Context, userID int64, recoveryHashes []string) error {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `update user set totp_enabled = 1 where id = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `delete from recovery_code where user = ?`, userID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryHashes {
+		_, err := tx.ExecContext(ctx, `insert into recovery_code (user, code_hash) values (?, ?)`, userID, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (store UserDB) DisableTOTP(ctx context.Context, userID int64) error {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update user set totp_secret = null, totp_enabled = 0 where id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `delete from recovery_code where user = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Consume the recovery code with the given hash. Reports whether the code existed.
+func (store UserDB) UseRecoveryCode(ctx context.Context, userID int64, hash string) (bool, error) {
+	result, err := store.db.ExecContext(ctx, `
+		delete from recovery_code
+		where user = ?
+			and code_hash = ?
+	`, userID, hash)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// The condition makes concurrent uses of the same code race for a single update.
+func (store UserDB) UseTOTPStep(ctx context.Context, userID, step int64) (bool, error) {
+	result, err := store.db.ExecContext(ctx, `
+		update user
+		set totp_last_step = ?
+		where id = ?
+			and totp_last_step < ?
+	`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// Save the hash of a single-use token. The token itself is never stored.
+func (store UserDB) CreateToken(ctx context.Context, userID int64, purpose, hash string, expires time.Time) error {
+	_, err := store.db.ExecContext(ctx, `
+		insert into user_token
+		(token_hash, user, purpose, expires) values
+			(?, ?, ?, ?)
+	`, hash, userID, purpose, expires)
+	return err
+}
+
+// Invalidate the token and return the user it was issued to.
+// Expired and unknown tokens yield ErrNotFound.
+func (store UserDB) ConsumeToken(ctx context.Context, purpose, hash string) (int64, error) {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	var expires time.Time
+	err = tx.QueryRowContext(ctx, `
+		select user, expires
+		from user_token
+		where token_hash = ?
+			and purpose = ?
+		for update
+	`, hash, purpose).Scan(&userID, &expires)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `delete from user_token where token_hash = ?`, hash); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if time.Now().After(expires) {
+		return 0, ErrNotFound
+	}
+	return userID, nil
+}
+
+func (store UserDB) SetEmailVerified(ctx context.Context, userID int64) error {
+	_, err := store.db.ExecContext(ctx, `update user set email_verified = 1 where id = ?`, userID)
+	return err
+}
+
+func (store UserDB) SetPassword(ctx context.Context, userID int64, pw string) error {
+	hash, err := password.Hash(pw)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.ExecContext(ctx, `update user set password_hash = ? where id = ?`, hash, userID)
+	return err
+}
+
+func (store UserDB) EndSessions(ctx context.Context, userID int64) error {
+	result, err := store.db.ExecContext(ctx, `update user set session_version = session_version + 1 where id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	return store.expectUser(ctx, result, userID)
+}
+
+// Change name and email of the user. A changed email has to be verified again.
+// If pw is set, the password is rehashed as well. This is required when renaming
+// users with a legacy hash as their name is part of it.
+func (store UserDB) UpdateProfile(ctx context.Context, userID int64, name, email, pw string) error {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		update user
+		set email_verified = email_verified and email = ?,
+			name = ?, email = ?
+		where id = ?
+	`, email, name, email, userID)
+	if err != nil {
+		if dup := duplicateUserErr(err); dup != nil {
+			return dup
+		}
+		return err
+	}
+	if pw != "" {
+		hash, err := password.Hash(pw)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `update user set password_hash = ? where id = ?`, hash, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Remove the user together with everything they own.
+func (store UserDB) DeleteUser(ctx context.Context, userID int64) error {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`delete from todo where owner = ?`,
+		`delete from todo_category where owner = ?`,
+		`delete from user_token where user = ?`,
+		`delete from recovery_code where user = ?`,
+		`delete from user_identity where user = ?`,
+	}
+	for _, q := range statements {
+		if _, err := tx.ExecContext(ctx, q, userID); err != nil {
+			return err
+		}
+	}
+	result, err := tx.ExecContext(ctx, `delete from user where id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+func (store UserDB) GetAllUsers(ctx context.Context) ([]model.User, error) {
+	rows, err := store.db.QueryContext(ctx, `select `+userColumns+` from user order by user.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := make([]model.User, 0)
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// Disabling the user also ends their sessions.
+func (store UserDB) SetDisabled(ctx context.Context, userID int64, disabled bool) error {
+	result, err := store.db.ExecContext(ctx, `
+		update user
+		set disabled = ?, session_version = session_version + ?
+		where id = ?
+	`, disabled, disabled, userID)
+	if err != nil {
+		return err
+	}
+	return store.expectUser(ctx, result, userID)
+}
+
+// Remove the password and end the sessions, so the user can only log in
+// again after a reset.
+func (store UserDB) ClearPassword(ctx context.Context, userID int64) error {
+	result, err := store.db.ExecContext(ctx, `
+		update user
+		set password_hash = '', session_version = session_version + 1
+		where id = ?
+	`, userID)
+	if err != nil {
+		return err
+	}
+	return store.expectUser(ctx, result, userID)
+}
+
+func (store UserDB) GetUserStats(ctx context.Context) (model.UserStats, error) {
+	var stats model.UserStats
+	err := store.db.QueryRowContext(ctx, `
+		select count(*), coalesce(sum(disabled), 0), coalesce(sum(role = ?), 0)
+		from user
+	`, model.RoleAdmin).Scan(&stats.Users, &stats.DisabledUsers, &stats.Admins)
+	return stats, err
+}
+
+// Report ErrNotFound if the update did not match the user. MySQL only counts
+// rows that actually changed, so a user already in the desired state has to
+// be told apart by looking it up.
+func (store UserDB) expectUser(ctx context.Context, result sql.Result, userID int64) error {
+	n, err := result.RowsAffected()
+	if err != nil || n != 0 {
+		return err
+	}
+	var exists bool
+	err = store.db.QueryRowContext(ctx, `select exists (select 1 from user where id = ?)`, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return nil
+}
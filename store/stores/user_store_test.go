@@ -0,0 +1,61 @@
+package stores
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSetDisabledTwice(t *testing.T) {
+	store := NewMySQLUserStore(openTestDB(t))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := store.SetDisabled(ctx, seedOwner, true); err != nil {
+			t.Fatalf("SetDisabled #%d: %v", i+1, err)
+		}
+		if err := store.ClearPassword(ctx, seedOwner); err != nil {
+			t.Fatalf("ClearPassword #%d: %v", i+1, err)
+		}
+	}
+	if err := store.SetDisabled(ctx, 1<<30, true); err != ErrNotFound {
+		t.Errorf("SetDisabled of a missing user: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestSessionVersion(t *testing.T) {
+	store := NewMySQLUserStore(openTestDB(t))
+	ctx := context.Background()
+
+	version := func() int64 {
+		u, err := store.GetUserByID(ctx, seedOwner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u.SessionVersion
+	}
+	start := version()
+	steps := []struct {
+		name   string
+		change func() error
+		ended  bool
+	}{
+		{"EndSessions", func() error { return store.EndSessions(ctx, seedOwner) }, true},
+		{"disable", func() error { return store.SetDisabled(ctx, seedOwner, true) }, true},
+		{"enable", func() error { return store.SetDisabled(ctx, seedOwner, false) }, false},
+		{"ClearPassword", func() error { return store.ClearPassword(ctx, seedOwner) }, true},
+	}
+	for _, step := range steps {
+		if err := step.change(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if step.ended {
+			start++
+		}
+		if got := version(); got != start {
+			t.Errorf("after %s: got session version %d, want %d", step.name, got, start)
+		}
+	}
+	if err := store.EndSessions(ctx, 1<<30); err != ErrNotFound {
+		t.Errorf("EndSessions of a missing user: got %v, want ErrNotFound", err)
+	}
+}